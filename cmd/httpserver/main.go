@@ -1,32 +1,51 @@
 package main
 
 import (
-	"crypto/sha256"
-	"errors"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
-	"github.com/DanilShapilov/httpfromtcp/internal/headers"
+	"github.com/DanilShapilov/httpfromtcp/internal/accesslog"
+	"github.com/DanilShapilov/httpfromtcp/internal/fileserver"
+	"github.com/DanilShapilov/httpfromtcp/internal/metrics"
+	"github.com/DanilShapilov/httpfromtcp/internal/proxy"
 	"github.com/DanilShapilov/httpfromtcp/internal/request"
 	"github.com/DanilShapilov/httpfromtcp/internal/response"
 	"github.com/DanilShapilov/httpfromtcp/internal/server"
+	"github.com/DanilShapilov/httpfromtcp/internal/sse"
+	"github.com/DanilShapilov/httpfromtcp/internal/tracing"
+	"github.com/DanilShapilov/httpfromtcp/internal/websocket"
 )
 
 const port = 42069
 
+var (
+	registry = metrics.NewRegistry()
+	tracer   = tracing.NewTracer(tracing.NewStdoutExporter())
+	httpbin  = &proxy.ReverseProxy{
+		Target:      &url.URL{Scheme: "https", Host: "httpbin.org"},
+		StripPrefix: "/httpbin",
+	}
+)
+
 func main() {
-	server, err := server.Serve(port, handler)
+	accessLog := accesslog.New(os.Stdout, accesslog.FormatCombined)
+	server, err := server.ServeConfig(server.Config{
+		Addr:    fmt.Sprintf(":%d", port),
+		Metrics: registry,
+		Route:   route,
+	}, accessLog.Handler(tracer.Handler(handler, route)))
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
 	defer server.Close()
-	log.Println("Server started on port", port)
+	log.Println("Server started on", server.Addr())
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -34,7 +53,25 @@ func main() {
 	log.Println("Server gracefully stopped")
 }
 
+// route maps requests to the handler that serves them, so unknown paths
+// don't each get their own metrics series
+func route(req *request.Request) string {
+	target := req.RequestLine.RequestTarget
+	switch {
+	case target == "/metrics", target == "/yourproblem", target == "/myproblem", target == "/video", target == "/ws",
+		target == "/events":
+		return target
+	case strings.HasPrefix(target, "/httpbin"):
+		return "/httpbin"
+	}
+	return "/"
+}
+
 func handler(w *response.Writer, req *request.Request) {
+	if req.RequestLine.RequestTarget == "/metrics" {
+		metrics.Handler(registry)(w, req)
+		return
+	}
 	if req.RequestLine.RequestTarget == "/yourproblem" {
 		handler400(w, req)
 		return
@@ -47,90 +84,68 @@ func handler(w *response.Writer, req *request.Request) {
 		videoHandler(w, req)
 		return
 	}
+	if req.RequestLine.RequestTarget == "/ws" {
+		echoHandler(w, req)
+		return
+	}
+	if req.RequestLine.RequestTarget == "/events" {
+		clockHandler(w, req)
+		return
+	}
 	if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin") {
-		proxyHandler(w, req)
+		httpbin.Handle(w, req)
 		return
 	}
 	handler200(w, req)
 }
 
-func videoHandler(w *response.Writer, req *request.Request) {
-	w.WriteStatusLine(response.StatusCodeSuccess)
-	const filepath = "assets/vim.mp4"
-	videoBytes, err := os.ReadFile(filepath)
+var upgrader = &websocket.Upgrader{}
+
+// echoHandler sends every WebSocket message back to its sender
+func echoHandler(w *response.Writer, req *request.Request) {
+	c, err := upgrader.Upgrade(w, req)
 	if err != nil {
-		handler500(w, nil)
 		return
 	}
-	h := response.GetDefaultHeaders(len(videoBytes))
-	h.Override("Content-Type", "video/mp4")
-	w.WriteHeaders(h)
-	w.WriteBody(videoBytes)
+	for {
+		typ, msg, err := c.ReadMessage()
+		if err != nil {
+			return
+		}
+		if err := c.WriteMessage(typ, msg); err != nil {
+			return
+		}
+	}
 }
 
-func proxyHandler(w *response.Writer, req *request.Request) {
-	target := strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin/")
-	url := "https://httpbin.org/" + target
-	fmt.Println("Proxying to", url)
-
-	res, err := http.Get(url)
+// clockHandler streams the time every second, numbering the events so a
+// reconnecting client picks up the count where it left off
+func clockHandler(w *response.Writer, req *request.Request) {
+	s, err := sse.NewStream(w, req, 0)
 	if err != nil {
-		handler500(w, req)
 		return
 	}
-	defer res.Body.Close()
-
-	w.WriteStatusLine(response.StatusCodeSuccess)
-	h := response.GetDefaultHeaders(0)
-	h.Override("Transfer-Encoding", "chunked")
-	h.Remove("Content-Length")
-	h.Override("Trailer", "X-Content-SHA256")
-	h.Set("Trailer", "X-Content-Length")
-	w.WriteHeaders(h)
-
-	const maxChunkSize = 1024
-	buf := make([]byte, maxChunkSize)
-	bufTotal := make([]byte, 0)
-	totalBytesRead := 0
+	defer s.Close()
+	n, _ := strconv.Atoi(s.LastEventID())
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
 	for {
-		numBytesRead, err := res.Body.Read(buf)
-		fmt.Println("Read", numBytesRead, "bytes")
-		if numBytesRead > 0 {
-			_, err = w.WriteChunkedBody(buf[:numBytesRead])
-			if err != nil {
-				fmt.Println("Error writing chunked body:", err)
-				break
+		select {
+		case t := <-ticker.C:
+			n++
+			if err := s.Send(sse.Event{ID: strconv.Itoa(n), Event: "tick", Data: t.Format(time.RFC3339)}); err != nil {
+				return
 			}
-			bufTotal = append(bufTotal, buf[:numBytesRead]...)
-			totalBytesRead += numBytesRead
-		}
-
-		if errors.Is(err, io.EOF) {
-			break
-		}
-		if err != nil {
-			fmt.Println("Error reading response body: ", err)
-			break
+		case <-s.Done():
+			return
 		}
 	}
+}
 
-	_, err = w.WriteChunkedBodyDone()
-	if err != nil {
-		fmt.Println("Error writing chunked body done:", err)
-	}
-
-	fmt.Println("BUF TOTAL LEN", len(bufTotal), "bytes")
-	bufHash := sha256.Sum256(bufTotal)
-	fmt.Printf("%x", bufHash)
-	trailers := headers.NewHeaders()
-	trailers.Override("X-Content-SHA256", fmt.Sprintf("%x", bufHash))
-	trailers.Override("X-Content-Length", fmt.Sprintf("%v", totalBytesRead))
-
-	err = w.WriteTrailers(trailers)
-	if err != nil {
-		fmt.Println("Error writing trailers:", err)
-	}
-	fmt.Println("Wrote trailers")
+// videoHandler streams the video with sendfile, answering range requests
+// so players can seek
+func videoHandler(w *response.Writer, req *request.Request) {
+	fileserver.ServeFile(w, req, "assets/vim.mp4", "video/mp4")
 }
 
 func handler400(w *response.Writer, _ *request.Request) {
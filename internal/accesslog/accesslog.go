@@ -0,0 +1,155 @@
+package accesslog
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/request"
+	"github.com/DanilShapilov/httpfromtcp/internal/response"
+	"github.com/DanilShapilov/httpfromtcp/internal/server"
+)
+
+type Format int
+
+const (
+	// FormatCommon is the NCSA Common Log Format:
+	// host ident authuser [date] "request" status bytes
+	FormatCommon Format = iota
+	// FormatCombined is FormatCommon followed by "referer" "user-agent"
+	FormatCombined
+	// FormatJSON writes one log/slog JSON record per request
+	FormatJSON
+)
+
+const clfTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// Entry is everything recorded about a single request.
+type Entry struct {
+	Time       time.Time
+	Method     string
+	Target     string
+	Proto      string
+	Status     response.StatusCode
+	Bytes      int
+	Duration   time.Duration
+	RemoteAddr string
+	User       string
+	Referer    string
+	UserAgent  string
+}
+
+// Logger writes one line per request to out in the chosen format.
+type Logger struct {
+	format Format
+	slog   *slog.Logger
+
+	mu  sync.Mutex // serializes writes so lines never interleave
+	out io.Writer
+
+	now func() time.Time
+}
+
+func New(out io.Writer, format Format) *Logger {
+	return &Logger{
+		format: format,
+		slog:   slog.New(slog.NewJSONHandler(out, nil)),
+		out:    out,
+		now:    time.Now,
+	}
+}
+
+// Handler returns a Handler that runs h and logs the request once h returns.
+func (l *Logger) Handler(h server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		start := l.now()
+		h(w, req)
+		l.Log(req.Context(), newEntry(start, l.now().Sub(start), w, req))
+	}
+}
+
+func newEntry(start time.Time, d time.Duration, w *response.Writer, req *request.Request) Entry {
+	e := Entry{
+		Time:       start,
+		Method:     req.RequestLine.Method,
+		Target:     req.RequestLine.RequestTarget,
+		Proto:      "HTTP/" + req.RequestLine.HttpVersion,
+		Status:     w.StatusCode(),
+		Bytes:      w.BytesWritten(),
+		Duration:   d,
+		RemoteAddr: req.RemoteAddr,
+	}
+	if req.ClientIdentity != nil {
+		e.User = req.ClientIdentity.Subject.CommonName
+	}
+	if req.Headers != nil {
+		e.Referer, _ = req.Headers.Get("Referer")
+		e.UserAgent, _ = req.Headers.Get("User-Agent")
+	}
+	return e
+}
+
+// Log writes a single entry.
+func (l *Logger) Log(ctx context.Context, e Entry) {
+	if l.format == FormatJSON {
+		l.slog.LogAttrs(ctx, slog.LevelInfo, "request",
+			slog.String("method", e.Method),
+			slog.String("target", e.Target),
+			slog.String("proto", e.Proto),
+			slog.Int("status", int(e.Status)),
+			slog.Int("bytes", e.Bytes),
+			slog.Duration("duration", e.Duration),
+			slog.String("remote_addr", e.RemoteAddr),
+			slog.String("user", e.User),
+			slog.String("referer", e.Referer),
+			slog.String("user_agent", e.UserAgent),
+		)
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s - %s [%s] \"%s %s %s\" %d %s",
+		host(e.RemoteAddr),
+		orDash(e.User),
+		e.Time.Format(clfTimeLayout),
+		e.Method, e.Target, e.Proto,
+		e.Status,
+		bytesField(e.Bytes),
+	)
+	if l.format == FormatCombined {
+		fmt.Fprintf(&b, " %q %q", orDash(e.Referer), orDash(e.UserAgent))
+	}
+	b.WriteByte('\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.out, b.String())
+}
+
+func host(remoteAddr string) string {
+	h, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return orDash(remoteAddr)
+	}
+	return h
+}
+
+// bytesField follows CLF in logging empty bodies as "-"
+func bytesField(n int) string {
+	if n == 0 {
+		return "-"
+	}
+	return fmt.Sprint(n)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,113 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/headers"
+	"github.com/DanilShapilov/httpfromtcp/internal/request"
+	"github.com/DanilShapilov/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testRequest() *request.Request {
+	h := headers.NewHeaders()
+	h.Set("Host", "localhost:42069")
+	h.Set("User-Agent", "curl/7.81.0")
+	h.Set("Referer", "http://example.com/")
+	return &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: "/coffee", HttpVersion: "1.1"},
+		Headers:     h,
+		RemoteAddr:  "127.0.0.1:51234",
+	}
+}
+
+func testHandler(w *response.Writer, _ *request.Request) {
+	body := []byte("hello")
+	w.WriteStatusLine(response.StatusCodeSuccess)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+// serve runs a request through a logger with a frozen clock where each
+// reading is 25ms after the previous one.
+func serve(format Format) string {
+	var out bytes.Buffer
+	l := New(&out, format)
+	now := time.Date(2000, time.October, 10, 13, 55, 36, 0, time.FixedZone("", -7*60*60))
+	l.now = func() time.Time {
+		t := now
+		now = now.Add(25 * time.Millisecond)
+		return t
+	}
+	l.Handler(testHandler)(response.NewWriter(io.Discard), testRequest())
+	return out.String()
+}
+
+func TestFormats(t *testing.T) {
+	// Test: Common Log Format
+	assert.Equal(t,
+		"127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] \"GET /coffee HTTP/1.1\" 200 5\n",
+		serve(FormatCommon))
+
+	// Test: Combined Log Format
+	assert.Equal(t,
+		"127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] \"GET /coffee HTTP/1.1\" 200 5 \"http://example.com/\" \"curl/7.81.0\"\n",
+		serve(FormatCombined))
+
+	// Test: JSON
+	var record map[string]any
+	require.NoError(t, json.Unmarshal([]byte(serve(FormatJSON)), &record))
+	assert.Equal(t, "request", record["msg"])
+	assert.Equal(t, "GET", record["method"])
+	assert.Equal(t, "/coffee", record["target"])
+	assert.Equal(t, float64(200), record["status"])
+	assert.Equal(t, float64(5), record["bytes"])
+	assert.Equal(t, float64(25*time.Millisecond), record["duration"])
+	assert.Equal(t, "127.0.0.1:51234", record["remote_addr"])
+	assert.Equal(t, "curl/7.81.0", record["user_agent"])
+
+	// Test: Missing fields are dashes
+	var out bytes.Buffer
+	New(&out, FormatCombined).Log(t.Context(), Entry{
+		Time:   time.Date(2000, time.October, 10, 13, 55, 36, 0, time.UTC),
+		Method: "HEAD", Target: "/", Proto: "HTTP/1.1", Status: 400,
+	})
+	assert.Equal(t, "- - - [10/Oct/2000:13:55:36 +0000] \"HEAD / HTTP/1.1\" 400 - \"-\" \"-\"\n", out.String())
+}
+
+func TestFileReopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	lf, err := OpenFile(path)
+	require.NoError(t, err)
+	defer lf.Close()
+	stop := lf.ReopenOnSIGHUP(func(err error) { t.Error(err) })
+	defer stop()
+
+	// Test: SIGHUP after a rename starts a fresh file
+	_, err = lf.Write([]byte("first\n"))
+	require.NoError(t, err)
+	require.NoError(t, os.Rename(path, path+".1"))
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}, 2*time.Second, 10*time.Millisecond)
+	_, err = lf.Write([]byte("second\n"))
+	require.NoError(t, err)
+
+	rotated, err := os.ReadFile(path + ".1")
+	require.NoError(t, err)
+	assert.Equal(t, "first\n", string(rotated))
+	current, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "second\n", string(current))
+}
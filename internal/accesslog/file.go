@@ -0,0 +1,84 @@
+package accesslog
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// File is an append-only log file that can be reopened in place, which is
+// how external rotation tools (logrotate and friends) expect a daemon to
+// behave: they rename the file and send SIGHUP.
+type File struct {
+	path string
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+func OpenFile(path string) (*File, error) {
+	f, err := openAppend(path)
+	if err != nil {
+		return nil, err
+	}
+	return &File{path: path, f: f}, nil
+}
+
+func openAppend(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+}
+
+func (lf *File) Write(p []byte) (int, error) {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	return lf.f.Write(p)
+}
+
+// Reopen closes the current file and opens path again, creating it if it
+// was moved away. On error the old file keeps receiving writes.
+func (lf *File) Reopen() error {
+	f, err := openAppend(lf.path)
+	if err != nil {
+		return err
+	}
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	old := lf.f
+	lf.f = f
+	return old.Close()
+}
+
+// ReopenOnSIGHUP reopens the file every time the process gets SIGHUP until
+// the returned stop function is called. Reopen errors go to onError, which
+// may be nil.
+func (lf *File) ReopenOnSIGHUP(onError func(error)) (stop func()) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-hup:
+				if err := lf.Reopen(); err != nil && onError != nil {
+					onError(err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(hup)
+			close(done)
+		})
+	}
+}
+
+func (lf *File) Close() error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	return lf.f.Close()
+}
@@ -0,0 +1,347 @@
+// Package client is an HTTP/1.1 client on top of the project's own response
+// parser, with keep-alive connection pooling.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/headers"
+	"github.com/DanilShapilov/httpfromtcp/internal/response"
+)
+
+const (
+	defaultMaxIdleConnsPerHost    = 2
+	defaultIdleTimeout            = 90 * time.Second
+	defaultMaxResponseHeaderBytes = 1 << 20
+)
+
+// Client sends requests over pooled connections. The zero value is ready to
+// use, and a Client is safe for concurrent use.
+type Client struct {
+	// TLSConfig is used for https URLs. ServerName defaults to the URL host.
+	TLSConfig *tls.Config
+	// DialTimeout bounds connecting, TLS handshake included. Zero means only
+	// the request context applies.
+	DialTimeout time.Duration
+	// MaxIdleConnsPerHost is how many idle connections are kept per scheme
+	// and host. Defaults to 2, negative disables keep-alive.
+	MaxIdleConnsPerHost int
+	// IdleTimeout is how long an idle connection is kept. Defaults to 90s.
+	IdleTimeout time.Duration
+	// MaxResponseHeaderBytes caps the status line plus headers of a
+	// response, and its trailers, so a broken or hostile server can't make
+	// the client buffer without end. Defaults to 1 MiB, negative means no
+	// limit.
+	MaxResponseHeaderBytes int
+
+	mu   sync.Mutex
+	idle map[string][]*persistConn
+}
+
+// DefaultClient is used by Get.
+var DefaultClient = &Client{}
+
+// Request is an outgoing request.
+type Request struct {
+	Method  string
+	URL     *url.URL
+	Headers headers.Headers
+	// Body is sent with a Content-Length. Keeping it in memory lets a request
+	// be sent again on a fresh connection when a pooled one turns out dead.
+	Body []byte
+}
+
+// NewRequest returns a request for method and rawURL with empty headers.
+func NewRequest(method, rawURL string, body []byte) (*Request, error) {
+	if !headers.IsToken(method) {
+		return nil, fmt.Errorf("client: invalid method %q", method)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("client: unsupported scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("client: missing host in %q", rawURL)
+	}
+	return &Request{Method: method, URL: u, Headers: headers.NewHeaders(), Body: body}, nil
+}
+
+// Response is a response whose body is read from the connection on demand.
+// Body must be closed; reading it to io.EOF first lets the connection be
+// reused.
+type Response struct {
+	StatusCode   response.StatusCode
+	ReasonPhrase string
+	HttpVersion  string
+	Headers      headers.Headers
+	// Trailers are complete once Body returned io.EOF.
+	Trailers headers.Headers
+	// ContentLength is -1 when the body is chunked, close delimited or
+	// absent.
+	ContentLength int
+	Body          io.ReadCloser
+}
+
+// Get sends a GET for rawURL with DefaultClient.
+func Get(ctx context.Context, rawURL string) (*Response, error) {
+	req, err := NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return DefaultClient.Do(ctx, req)
+}
+
+// Do sends req and reads the response headers. Cancelling ctx aborts the
+// request, including reading the body.
+func (c *Client) Do(ctx context.Context, req *Request) (*Response, error) {
+	if err := validRequest(req); err != nil {
+		return nil, err
+	}
+	key := connKey(req.URL)
+	for attempt := 0; ; attempt++ {
+		pc, err := c.getConn(ctx, key, req.URL)
+		if err != nil {
+			return nil, err
+		}
+		res, err := c.roundTrip(ctx, pc, req)
+		if err == nil {
+			return res, nil
+		}
+		pc.conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		// a pooled connection the server already closed fails before a
+		// single byte of response, so the request can go out again
+		var stale *staleConnError
+		if attempt == 0 && errors.As(err, &stale) {
+			continue
+		}
+		return nil, err
+	}
+}
+
+// staleConnError is a failure on a reused connection before any response
+// byte arrived.
+type staleConnError struct{ err error }
+
+func (e *staleConnError) Error() string { return e.err.Error() }
+func (e *staleConnError) Unwrap() error { return e.err }
+
+func (c *Client) roundTrip(ctx context.Context, pc *persistConn, req *Request) (*Response, error) {
+	stop := context.AfterFunc(ctx, func() { pc.conn.Close() })
+	bytesRead := pc.r.BytesRead()
+
+	// wrap marks errors that happened before anything came back as safe to
+	// retry, which for a request that may have been processed only holds
+	// when the write itself failed or the method is idempotent
+	wrap := func(err error, written bool) error {
+		stop()
+		if pc.reused && pc.r.BytesRead() == bytesRead && (!written || IsIdempotent(req.Method)) {
+			return &staleConnError{err}
+		}
+		return err
+	}
+
+	if _, err := pc.conn.Write(requestBytes(req)); err != nil {
+		return nil, wrap(err, false)
+	}
+
+	var parsed *response.Response
+	for {
+		var err error
+		parsed, err = pc.r.ReadResponseHeader(req.Method)
+		if err != nil {
+			return nil, wrap(err, true)
+		}
+		// interim responses are followed by the real one
+		code := parsed.StatusLine.StatusCode
+		if code < 100 || code >= 200 || code == 101 {
+			break
+		}
+	}
+
+	res := &Response{
+		StatusCode:    parsed.StatusLine.StatusCode,
+		ReasonPhrase:  parsed.StatusLine.ReasonPhrase,
+		HttpVersion:   parsed.StatusLine.HttpVersion,
+		Headers:       parsed.Headers,
+		Trailers:      parsed.Trailers,
+		ContentLength: parsed.ContentLength(),
+	}
+	b := &body{
+		client:   c,
+		pc:       pc,
+		ctx:      ctx,
+		r:        parsed.BodyReader(),
+		stop:     stop,
+		reusable: c.reusable(req, parsed),
+	}
+	if parsed.Complete() {
+		// nothing more to read, the connection can go back right away
+		b.release(true)
+	}
+	res.Body = b
+	return res, nil
+}
+
+// validRequest makes sure req serializes to a single request, with nothing
+// a header could smuggle in.
+func validRequest(req *Request) error {
+	if !headers.IsToken(req.Method) {
+		return fmt.Errorf("client: invalid method %q", req.Method)
+	}
+	// All splits the lines of a field, so any CR, LF or NUL left is in a
+	// value
+	for key, value := range req.Headers.All() {
+		if !headers.IsToken(key) {
+			return fmt.Errorf("client: invalid header name %q", key)
+		}
+		if strings.ContainsAny(value, "\r\n\x00") {
+			return fmt.Errorf("client: invalid value for header %q", key)
+		}
+	}
+	return nil
+}
+
+// requestBytes serializes req. The Host header and Content-Length are
+// always set from the URL and Body.
+func requestBytes(req *Request) []byte {
+	var buf bytes.Buffer
+	target := req.URL.RequestURI()
+	fmt.Fprintf(&buf, "%s %s HTTP/1.1\r\n", req.Method, target)
+	fmt.Fprintf(&buf, "Host: %s\r\n", req.URL.Host)
+	for key, value := range req.Headers.All() {
+		switch strings.ToLower(key) {
+		case "host", "content-length", "transfer-encoding":
+			continue
+		}
+		fmt.Fprintf(&buf, "%s: %s\r\n", key, value)
+	}
+	if len(req.Body) > 0 || req.Method == "POST" || req.Method == "PUT" || req.Method == "PATCH" {
+		fmt.Fprintf(&buf, "Content-Length: %s\r\n", strconv.Itoa(len(req.Body)))
+	}
+	buf.WriteString("\r\n")
+	buf.Write(req.Body)
+	return buf.Bytes()
+}
+
+// reusable reports whether the connection can carry another request once
+// res has been read in full.
+func (c *Client) reusable(req *Request, res *response.Response) bool {
+	if c.MaxIdleConnsPerHost < 0 || res.StatusLine.HttpVersion != "1.1" || res.StatusLine.StatusCode == 101 || res.CloseDelimited() {
+		return false
+	}
+	for _, h := range []headers.Headers{req.Headers, res.Headers} {
+		if connection, exists := h.Get("connection"); exists && hasToken(connection, "close") {
+			return false
+		}
+	}
+	return true
+}
+
+func hasToken(list, token string) bool {
+	for _, t := range strings.Split(list, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsIdempotent reports whether a request with method can safely be sent
+// again (RFC 9110 section 9.2.2).
+func IsIdempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+// body hands the connection back to the pool once the response has been
+// read to the end, and closes it otherwise.
+type body struct {
+	client   *Client
+	pc       *persistConn
+	ctx      context.Context
+	r        io.Reader
+	stop     func() bool
+	reusable bool
+
+	mu       sync.Mutex
+	released bool // the connection is no longer ours
+	closed   bool
+}
+
+var errBodyClosed = errors.New("client: read on closed response body")
+
+func (b *body) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, errBodyClosed
+	}
+	// once released, r only serves what was already parsed
+	n, err := b.r.Read(p)
+	if err != nil && !b.released {
+		b.release(errors.Is(err, io.EOF))
+	}
+	if err != nil && !errors.Is(err, io.EOF) && b.ctx.Err() != nil {
+		err = b.ctx.Err()
+	}
+	return n, err
+}
+
+// WriteTo lets io.Copy hand the copy to the body reader, which can move the
+// body from the connection to w without going through a buffer here.
+func (b *body) WriteTo(w io.Writer) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, errBodyClosed
+	}
+	n, err := io.Copy(w, b.r)
+	if !b.released {
+		b.release(err == nil)
+	}
+	if err != nil && b.ctx.Err() != nil {
+		err = b.ctx.Err()
+	}
+	return n, err
+}
+
+func (b *body) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	if !b.released {
+		b.release(false)
+	}
+	return nil
+}
+
+// release gives up the connection, to the pool if the whole response was
+// read and nothing went wrong.
+func (b *body) release(complete bool) {
+	b.released = true
+	// stop reports false when the context already closed the connection
+	if b.stop() && complete && b.reusable && b.pc.r.Buffered() == 0 {
+		b.client.putIdle(b.pc)
+		return
+	}
+	b.pc.conn.Close()
+}
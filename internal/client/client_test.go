@@ -0,0 +1,307 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/headers"
+	"github.com/DanilShapilov/httpfromtcp/internal/request"
+	"github.com/DanilShapilov/httpfromtcp/internal/response"
+	"github.com/DanilShapilov/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testHandler(w *response.Writer, req *request.Request) {
+	switch req.RequestLine.RequestTarget {
+	case "/stream":
+		h := response.GetDefaultHeaders(0)
+		h.Remove("Content-Length")
+		h.Override("Transfer-Encoding", "chunked")
+		h.Override("Trailer", "X-Checksum")
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte("hello "))
+		w.WriteChunkedBody([]byte("world"))
+		w.WriteChunkedBodyDone()
+		trailers := headers.NewHeaders()
+		trailers.Set("X-Checksum", "abc123")
+		w.WriteTrailers(trailers)
+	default:
+		body := []byte(fmt.Sprintf("%s %s %s", req.RequestLine.Method, req.RequestLine.RequestTarget, req.Body))
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}
+}
+
+func readBody(t *testing.T, res *Response) string {
+	t.Helper()
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestClient(t *testing.T) {
+	srv, err := server.ServeConfig(server.Config{Addr: "127.0.0.1:0"}, testHandler)
+	require.NoError(t, err)
+	defer srv.Close()
+	base := "http://" + srv.Addr().String()
+	c := &Client{}
+
+	// Test: Content-Length body
+	res, err := Get(context.Background(), base+"/hello?x=1")
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCodeSuccess, res.StatusCode)
+	assert.Equal(t, "OK", res.ReasonPhrase)
+	assert.Equal(t, 15, res.ContentLength)
+	assert.Equal(t, "GET /hello?x=1 ", readBody(t, res))
+
+	// Test: Request body is sent with a Content-Length
+	req, err := NewRequest("POST", base+"/echo", []byte("payload"))
+	require.NoError(t, err)
+	res, err = c.Do(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "POST /echo payload", readBody(t, res))
+
+	// Test: Chunked body with trailers
+	req, err = NewRequest("GET", base+"/stream", nil)
+	require.NoError(t, err)
+	res, err = c.Do(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, -1, res.ContentLength)
+	assert.Equal(t, "hello world", readBody(t, res))
+	checksum, _ := res.Trailers.Get("X-Checksum")
+	assert.Equal(t, "abc123", checksum)
+
+	// Test: HEAD responses have no body despite their Content-Length
+	req, err = NewRequest("HEAD", base+"/", nil)
+	require.NoError(t, err)
+	res, err = c.Do(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "", readBody(t, res))
+
+	// Test: Connection: close responses are not pooled
+	assert.Empty(t, c.idle)
+
+	// Test: Bad URLs
+	_, err = NewRequest("GET", "ftp://example.com/", nil)
+	require.Error(t, err)
+	_, err = NewRequest("GET", "/relative", nil)
+	require.Error(t, err)
+
+	// Test: Methods and headers that would split the request are refused
+	_, err = NewRequest("GET / HTTP/1.1\r\nX: y\r\n\r\nGET", base+"/", nil)
+	require.Error(t, err)
+	req, err = NewRequest("GET", base+"/", nil)
+	require.NoError(t, err)
+	req.Method = "GET /x"
+	_, err = c.Do(context.Background(), req)
+	require.Error(t, err)
+	for _, h := range [][2]string{{"X-Bad", "a\r\nX-Injected: b"}, {"X-Bad", "a\rb"}, {"X-Bad", "a\x00b"}, {"X Bad", "a"}, {"X-Bad:", "a"}} {
+		req, err = NewRequest("GET", base+"/", nil)
+		require.NoError(t, err)
+		req.Headers.Override(h[0], h[1])
+		_, err = c.Do(context.Background(), req)
+		require.Error(t, err, h)
+	}
+}
+
+// keepAliveServer answers every request on a connection with "n" for the
+// n-th request it served, then closes the connection after perConn
+// responses. It counts accepted connections.
+type keepAliveServer struct {
+	ln       net.Listener
+	accepted atomic.Int32
+	served   atomic.Int32
+	perConn  int
+}
+
+func startKeepAliveServer(t *testing.T, perConn int) *keepAliveServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &keepAliveServer{ln: ln, perConn: perConn}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.accepted.Add(1)
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *keepAliveServer) serve(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	for i := 0; i < s.perConn; i++ {
+		// requests in these tests have no body
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if line == "\r\n" {
+				break
+			}
+		}
+		body := fmt.Sprint(s.served.Add(1))
+		fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+	}
+}
+
+func (s *keepAliveServer) url() string {
+	return "http://" + s.ln.Addr().String() + "/"
+}
+
+func TestKeepAlive(t *testing.T) {
+	// Test: Sequential requests share one connection
+	s := startKeepAliveServer(t, 100)
+	c := &Client{}
+	for i := 1; i <= 3; i++ {
+		req, err := NewRequest("GET", s.url(), nil)
+		require.NoError(t, err)
+		res, err := c.Do(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprint(i), readBody(t, res))
+	}
+	assert.Equal(t, int32(1), s.accepted.Load())
+
+	// Test: A response that came in whole with its headers frees the
+	// connection before Body is read
+	req, err := NewRequest("GET", s.url(), nil)
+	require.NoError(t, err)
+	res, err := c.Do(context.Background(), req)
+	require.NoError(t, err)
+	assert.Len(t, c.idle[connKey(req.URL)], 1)
+	res.Body.Close()
+	_, err = res.Body.Read(make([]byte, 1))
+	require.ErrorIs(t, err, errBodyClosed)
+
+	// Test: Idle connections the server closed are redialed
+	s = startKeepAliveServer(t, 1)
+	for i := 1; i <= 3; i++ {
+		req, err := NewRequest("GET", s.url(), nil)
+		require.NoError(t, err)
+		res, err := c.Do(context.Background(), req)
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprint(i), readBody(t, res))
+		// let the server's close reach us
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, int32(3), s.accepted.Load())
+
+	// Test: Idle connections are capped per host
+	s = startKeepAliveServer(t, 100)
+	c = &Client{MaxIdleConnsPerHost: 1}
+	var bodies []*Response
+	for i := 0; i < 3; i++ {
+		req, err := NewRequest("GET", s.url(), nil)
+		require.NoError(t, err)
+		res, err := c.Do(context.Background(), req)
+		require.NoError(t, err)
+		bodies = append(bodies, res)
+	}
+	for _, res := range bodies {
+		readBody(t, res)
+	}
+	assert.Len(t, c.idle[connKey(mustURL(t, s.url()))], 1)
+	c.CloseIdleConnections()
+	assert.Empty(t, c.idle)
+}
+
+func TestClientContext(t *testing.T) {
+	// Test: Cancelling the context aborts a request waiting for headers
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(io.Discard, conn)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, err := NewRequest("GET", "http://"+ln.Addr().String()+"/", nil)
+	require.NoError(t, err)
+	_, err = (&Client{}).Do(ctx, req)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func mustURL(t *testing.T, rawURL string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	require.NoError(t, err)
+	return u
+}
+
+func TestCloseDelimited(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		bufio.NewReader(conn).ReadString('\n')
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\n\r\nuntil close")
+	}()
+
+	// Test: A body without a length ends with the connection, which is
+	// then not pooled
+	c := &Client{}
+	req, err := NewRequest("GET", "http://"+ln.Addr().String()+"/", nil)
+	require.NoError(t, err)
+	res, err := c.Do(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, -1, res.ContentLength)
+	assert.Equal(t, "until close", readBody(t, res))
+	assert.Empty(t, c.idle)
+}
+
+func TestResponseHeaderLimit(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		bufio.NewReader(conn).ReadString('\n')
+		io.WriteString(conn, "HTTP/1.1 200 OK\r\nX-Endless: ")
+		for {
+			if _, err := io.WriteString(conn, strings.Repeat("a", 1024)); err != nil {
+				return
+			}
+		}
+	}()
+
+	// Test: A server sending endless headers fails the request
+	c := &Client{MaxResponseHeaderBytes: 4096}
+	req, err := NewRequest("GET", "http://"+ln.Addr().String()+"/", nil)
+	require.NoError(t, err)
+	_, err = c.Do(context.Background(), req)
+	require.ErrorIs(t, err, response.ErrHeaderTooLarge)
+}
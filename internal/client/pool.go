@@ -0,0 +1,146 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/response"
+)
+
+// persistConn is a connection that may carry several requests in turn.
+type persistConn struct {
+	key       string // see connKey
+	conn      net.Conn
+	r         *response.Reader
+	reused    bool
+	idleSince time.Time
+}
+
+// newPersistConn reads responses from conn itself rather than a wrapper, so
+// bodies can be spliced from it.
+func newPersistConn(key string, conn net.Conn, limits response.Limits) *persistConn {
+	return &persistConn{key: key, conn: conn, r: response.NewReaderWithLimits(conn, limits)}
+}
+
+// connKey identifies the connections that can be shared between URLs.
+func connKey(u *url.URL) string {
+	return u.Scheme + "://" + hostPort(u)
+}
+
+func hostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+// getConn returns the most recently used idle connection for key, or dials
+// a new one.
+func (c *Client) getConn(ctx context.Context, key string, u *url.URL) (*persistConn, error) {
+	if pc := c.popIdle(key); pc != nil {
+		return pc, nil
+	}
+	return c.dial(ctx, key, u)
+}
+
+func (c *Client) popIdle(key string) *persistConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pool := c.idle[key]
+	for len(pool) > 0 {
+		pc := pool[len(pool)-1]
+		pool = pool[:len(pool)-1]
+		if time.Since(pc.idleSince) > c.idleTimeout() {
+			pc.conn.Close()
+			continue
+		}
+		c.idle[key] = pool
+		pc.reused = true
+		return pc
+	}
+	delete(c.idle, key)
+	return nil
+}
+
+func (c *Client) putIdle(pc *persistConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.idle[pc.key]) >= c.maxIdleConnsPerHost() {
+		pc.conn.Close()
+		return
+	}
+	if c.idle == nil {
+		c.idle = map[string][]*persistConn{}
+	}
+	pc.idleSince = time.Now()
+	c.idle[pc.key] = append(c.idle[pc.key], pc)
+}
+
+// CloseIdleConnections closes the pooled connections. Connections in use
+// are not affected.
+func (c *Client) CloseIdleConnections() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, pool := range c.idle {
+		for _, pc := range pool {
+			pc.conn.Close()
+		}
+	}
+	c.idle = nil
+}
+
+func (c *Client) dial(ctx context.Context, key string, u *url.URL) (*persistConn, error) {
+	if c.DialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.DialTimeout)
+		defer cancel()
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", hostPort(u))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "https" {
+		cfg := &tls.Config{}
+		if c.TLSConfig != nil {
+			cfg = c.TLSConfig.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = u.Hostname()
+		}
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	return newPersistConn(key, conn, response.Limits{MaxHeaderBytes: c.maxResponseHeaderBytes()}), nil
+}
+
+func (c *Client) maxIdleConnsPerHost() int {
+	if c.MaxIdleConnsPerHost == 0 {
+		return defaultMaxIdleConnsPerHost
+	}
+	return c.MaxIdleConnsPerHost
+}
+
+func (c *Client) maxResponseHeaderBytes() int {
+	if c.MaxResponseHeaderBytes == 0 {
+		return defaultMaxResponseHeaderBytes
+	}
+	return c.MaxResponseHeaderBytes
+}
+
+func (c *Client) idleTimeout() time.Duration {
+	if c.IdleTimeout <= 0 {
+		return defaultIdleTimeout
+	}
+	return c.IdleTimeout
+}
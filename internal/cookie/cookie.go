@@ -0,0 +1,227 @@
+// Package cookie reads the cookies of a request and builds Set-Cookie lines,
+// following RFC 6265 and the Partitioned attribute of CHIPS.
+package cookie
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/headers"
+)
+
+// timeFormat is the IMF-fixdate format of Expires, always in GMT.
+const timeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+var (
+	ErrNoCookie = errors.New("cookie: named cookie not present")
+	ErrInvalid  = errors.New("cookie: invalid cookie")
+)
+
+// SameSite restricts sending a cookie along with cross-site requests.
+type SameSite int
+
+const (
+	// SameSiteDefault leaves the attribute out, browsers then treat the
+	// cookie as Lax.
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	// SameSiteNone needs Secure, browsers drop the cookie otherwise.
+	SameSiteNone
+)
+
+func (s SameSite) String() string {
+	switch s {
+	case SameSiteLax:
+		return "Lax"
+	case SameSiteStrict:
+		return "Strict"
+	case SameSiteNone:
+		return "None"
+	}
+	return ""
+}
+
+// Cookie is a cookie sent by a client or set by a server. Only Name and
+// Value are sent by clients, the attributes are for Set-Cookie.
+type Cookie struct {
+	Name  string
+	Value string
+
+	Path   string
+	Domain string
+	// Expires is left out when zero.
+	Expires time.Time
+	// MaxAge is in seconds and takes precedence over Expires. It is left
+	// out when 0, a negative MaxAge deletes the cookie right away.
+	MaxAge   int
+	Secure   bool
+	HttpOnly bool
+	SameSite SameSite
+	// Partitioned keeps a separate cookie jar per top level site. It needs
+	// Secure.
+	Partitioned bool
+}
+
+// Parse returns the cookies of a Cookie header value. Malformed pairs are
+// skipped.
+func Parse(value string) []*Cookie {
+	var cookies []*Cookie
+	for pair := range strings.SplitSeq(value, ";") {
+		name, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || !headers.IsToken(name) {
+			continue
+		}
+		val, ok = parseValue(val)
+		if !ok {
+			continue
+		}
+		cookies = append(cookies, &Cookie{Name: name, Value: val})
+	}
+	return cookies
+}
+
+// ParseSetCookie parses a Set-Cookie line, as clients receive them.
+// Unknown and malformed attributes are ignored.
+func ParseSetCookie(line string) (*Cookie, error) {
+	parts := strings.Split(line, ";")
+	name, val, ok := strings.Cut(strings.TrimSpace(parts[0]), "=")
+	if !ok || !headers.IsToken(name) {
+		return nil, fmt.Errorf("%w: malformed name in %q", ErrInvalid, line)
+	}
+	val, ok = parseValue(val)
+	if !ok {
+		return nil, fmt.Errorf("%w: malformed value of %s", ErrInvalid, name)
+	}
+	c := &Cookie{Name: name, Value: val}
+	for _, attr := range parts[1:] {
+		key, attrValue, _ := strings.Cut(strings.TrimSpace(attr), "=")
+		attrValue = strings.TrimSpace(attrValue)
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "path":
+			c.Path = attrValue
+		case "domain":
+			c.Domain = strings.TrimPrefix(attrValue, ".")
+		case "expires":
+			if t, err := time.Parse(timeFormat, attrValue); err == nil {
+				c.Expires = t
+			}
+		case "max-age":
+			if n, err := strconv.Atoi(attrValue); err == nil {
+				if n <= 0 {
+					n = -1
+				}
+				c.MaxAge = n
+			}
+		case "secure":
+			c.Secure = true
+		case "httponly":
+			c.HttpOnly = true
+		case "samesite":
+			switch strings.ToLower(attrValue) {
+			case "lax":
+				c.SameSite = SameSiteLax
+			case "strict":
+				c.SameSite = SameSiteStrict
+			case "none":
+				c.SameSite = SameSiteNone
+			}
+		case "partitioned":
+			c.Partitioned = true
+		}
+	}
+	return c, nil
+}
+
+// parseValue strips the optional quotes around a cookie value. Clients are
+// more lenient than servers should be, so spaces and commas are accepted.
+func parseValue(value string) (string, bool) {
+	if len(value) > 1 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; c < 0x20 || c >= 0x7f || c == '"' || c == ';' || c == '\\' {
+			return "", false
+		}
+	}
+	return value, true
+}
+
+// Valid reports why browsers would reject c, or nil. Values are limited to
+// the cookie octets of RFC 6265 section 4.1.1, anything else has to be
+// encoded, e.g. with base64.RawURLEncoding.
+func (c *Cookie) Valid() error {
+	if !headers.IsToken(c.Name) {
+		return fmt.Errorf("%w: name %q is not a token", ErrInvalid, c.Name)
+	}
+	for i := 0; i < len(c.Value); i++ {
+		if !isCookieOctet(c.Value[i]) {
+			return fmt.Errorf("%w: invalid byte %q in value of %s", ErrInvalid, c.Value[i], c.Name)
+		}
+	}
+	for _, attr := range []string{c.Path, c.Domain} {
+		if strings.ContainsFunc(attr, func(r rune) bool { return r < 0x20 || r == 0x7f || r == ';' }) {
+			return fmt.Errorf("%w: invalid attribute %q of %s", ErrInvalid, attr, c.Name)
+		}
+	}
+	if !c.Expires.IsZero() && c.Expires.Year() < 1601 {
+		return fmt.Errorf("%w: expiry of %s before 1601", ErrInvalid, c.Name)
+	}
+	if (c.SameSite == SameSiteNone || c.Partitioned) && !c.Secure {
+		return fmt.Errorf("%w: %s needs Secure", ErrInvalid, c.Name)
+	}
+	return nil
+}
+
+func isCookieOctet(c byte) bool {
+	return c == 0x21 || c >= 0x23 && c <= 0x2b || c >= 0x2d && c <= 0x3a ||
+		c >= 0x3c && c <= 0x5b || c >= 0x5d && c <= 0x7e
+}
+
+// String returns the Set-Cookie value for c, without checking it is valid.
+func (c *Cookie) String() string {
+	var b strings.Builder
+	b.WriteString(c.Name)
+	b.WriteByte('=')
+	b.WriteString(c.Value)
+	if c.Path != "" {
+		b.WriteString("; Path=" + c.Path)
+	}
+	if c.Domain != "" {
+		b.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+	}
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=" + c.Expires.UTC().Format(timeFormat))
+	}
+	switch {
+	case c.MaxAge > 0:
+		b.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	case c.MaxAge < 0:
+		b.WriteString("; Max-Age=0")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.SameSite != SameSiteDefault {
+		b.WriteString("; SameSite=" + c.SameSite.String())
+	}
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+	return b.String()
+}
+
+// Set adds a Set-Cookie line for c to h, next to the ones already there.
+func Set(h headers.Headers, c *Cookie) error {
+	if err := c.Valid(); err != nil {
+		return err
+	}
+	h.Set("Set-Cookie", c.String())
+	return nil
+}
@@ -0,0 +1,110 @@
+package cookie
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	// Test: Several pairs, quoted values and surrounding spaces
+	cookies := Parse(`session=abc123; theme="dark";  lang=en ; empty=`)
+	require.Len(t, cookies, 4)
+	assert.Equal(t, Cookie{Name: "session", Value: "abc123"}, *cookies[0])
+	assert.Equal(t, Cookie{Name: "theme", Value: "dark"}, *cookies[1])
+	assert.Equal(t, Cookie{Name: "lang", Value: "en"}, *cookies[2])
+	assert.Equal(t, Cookie{Name: "empty", Value: ""}, *cookies[3])
+
+	// Test: Malformed pairs are skipped
+	cookies = Parse(`novalue; bad name=1; ok=1; bad="a"b"; ctl=a` + "\x01" + `b`)
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "ok", cookies[0].Name)
+
+	assert.Empty(t, Parse(""))
+}
+
+func TestString(t *testing.T) {
+	// Test: Every attribute
+	c := &Cookie{
+		Name:        "session",
+		Value:       "abc123",
+		Path:        "/",
+		Domain:      ".example.com",
+		Expires:     time.Date(2024, 5, 1, 14, 0, 0, 0, time.FixedZone("CEST", 2*60*60)),
+		MaxAge:      3600,
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    SameSiteNone,
+		Partitioned: true,
+	}
+	require.NoError(t, c.Valid())
+	assert.Equal(t, "session=abc123; Path=/; Domain=example.com; Expires=Wed, 01 May 2024 12:00:00 GMT; "+
+		"Max-Age=3600; HttpOnly; Secure; SameSite=None; Partitioned", c.String())
+
+	// Test: Only name and value
+	assert.Equal(t, "a=", (&Cookie{Name: "a"}).String())
+
+	// Test: Negative MaxAge deletes the cookie
+	assert.Equal(t, "a=; Max-Age=0", (&Cookie{Name: "a", MaxAge: -1}).String())
+
+	// Test: Invalid cookies
+	for _, c := range []*Cookie{
+		{Name: ""},
+		{Name: "bad name"},
+		{Name: "a", Value: "has space"},
+		{Name: "a", Value: "semi;colon"},
+		{Name: "a", Value: "comma,"},
+		{Name: "a", Value: `"quoted"`},
+		{Name: "a", Path: "/;evil"},
+		{Name: "a", Domain: "example.com\r\n"},
+		{Name: "a", Expires: time.Date(1600, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Name: "a", SameSite: SameSiteNone},
+		{Name: "a", Partitioned: true},
+	} {
+		assert.ErrorIs(t, c.Valid(), ErrInvalid, "%+v", c)
+	}
+}
+
+func TestParseSetCookie(t *testing.T) {
+	// Test: String round trips
+	c := &Cookie{
+		Name:     "session",
+		Value:    "abc123",
+		Path:     "/app",
+		Domain:   "example.com",
+		Expires:  time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		MaxAge:   60,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: SameSiteStrict,
+	}
+	parsed, err := ParseSetCookie(c.String())
+	require.NoError(t, err)
+	assert.Equal(t, c, parsed)
+
+	// Test: Attribute names are case insensitive, unknown ones ignored
+	parsed, err = ParseSetCookie(`id="42"; path=/; SECURE; samesite=lax; max-age=0; Priority=High`)
+	require.NoError(t, err)
+	assert.Equal(t, &Cookie{Name: "id", Value: "42", Path: "/", Secure: true, SameSite: SameSiteLax, MaxAge: -1}, parsed)
+
+	// Test: Malformed lines
+	_, err = ParseSetCookie("no pair")
+	assert.ErrorIs(t, err, ErrInvalid)
+	_, err = ParseSetCookie("a=b\\c; Path=/")
+	assert.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestSet(t *testing.T) {
+	// Test: Each cookie gets its own Set-Cookie line
+	h := headers.NewHeaders()
+	require.NoError(t, Set(h, &Cookie{Name: "a", Value: "1", Expires: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}))
+	require.NoError(t, Set(h, &Cookie{Name: "b", Value: "2", HttpOnly: true}))
+	assert.Equal(t, []string{"a=1; Expires=Wed, 01 May 2024 12:00:00 GMT", "b=2; HttpOnly"}, h.Values("set-cookie"))
+
+	// Test: Invalid cookies are not set
+	assert.ErrorIs(t, Set(h, &Cookie{Name: "c", Value: "x y"}), ErrInvalid)
+	assert.Len(t, h.Values("set-cookie"), 2)
+}
@@ -0,0 +1,107 @@
+// Package digest formats and parses the integrity fields of RFC 9530,
+// Content-Digest and Repr-Digest.
+package digest
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// Algorithm is a hash algorithm key from the HTTP Digest Algorithm Values
+// registry.
+type Algorithm string
+
+const (
+	SHA256 Algorithm = "sha-256"
+	SHA512 Algorithm = "sha-512"
+)
+
+var ErrMalformed = errors.New("malformed digest field")
+
+// New returns a hash for alg, or nil if alg is not supported.
+func New(alg Algorithm) hash.Hash {
+	switch alg {
+	case SHA256:
+		return sha256.New()
+	case SHA512:
+		return sha512.New()
+	}
+	return nil
+}
+
+// Supported reports whether alg can be computed.
+func Supported(alg Algorithm) bool {
+	return alg == SHA256 || alg == SHA512
+}
+
+// Digester hashes data with several algorithms at once.
+type Digester struct {
+	algs   []Algorithm
+	hashes []hash.Hash
+}
+
+// NewDigester returns a Digester for algs, which must be supported.
+func NewDigester(algs ...Algorithm) (*Digester, error) {
+	d := &Digester{}
+	for _, alg := range algs {
+		h := New(alg)
+		if h == nil {
+			return nil, fmt.Errorf("unsupported digest algorithm %q", alg)
+		}
+		d.algs = append(d.algs, alg)
+		d.hashes = append(d.hashes, h)
+	}
+	return d, nil
+}
+
+func (d *Digester) Write(p []byte) (int, error) {
+	for _, h := range d.hashes {
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+// Value returns the field value for what has been written so far, like
+// "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:".
+func (d *Digester) Value() string {
+	items := make([]string, len(d.algs))
+	for i, alg := range d.algs {
+		items[i] = Format(alg, d.hashes[i].Sum(nil))
+	}
+	return strings.Join(items, ", ")
+}
+
+// Format returns a single dictionary member for sum.
+func Format(alg Algorithm, sum []byte) string {
+	return fmt.Sprintf("%s=:%s:", alg, base64.StdEncoding.EncodeToString(sum))
+}
+
+// Parse parses a Content-Digest or Repr-Digest field value into digests by
+// algorithm. Parameters are ignored, as the RFC asks.
+func Parse(value string) (map[Algorithm][]byte, error) {
+	digests := map[Algorithm][]byte{}
+	for _, member := range strings.Split(value, ",") {
+		member = strings.TrimSpace(member)
+		if member == "" {
+			continue
+		}
+		if i := strings.IndexByte(member, ';'); i != -1 {
+			member = member[:i]
+		}
+		key, bs, ok := strings.Cut(member, "=")
+		if !ok || key == "" || len(bs) < 2 || bs[0] != ':' || bs[len(bs)-1] != ':' {
+			return nil, fmt.Errorf("%w: %q", ErrMalformed, member)
+		}
+		sum, err := base64.StdEncoding.DecodeString(bs[1 : len(bs)-1])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrMalformed, member)
+		}
+		digests[Algorithm(strings.ToLower(key))] = sum
+	}
+	return digests, nil
+}
@@ -0,0 +1,46 @@
+package digest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDigester(t *testing.T) {
+	// Test: RFC 9530 example value
+	d, err := NewDigester(SHA256)
+	require.NoError(t, err)
+	d.Write([]byte(`{"hello": `))
+	d.Write([]byte(`"world"}`))
+	assert.Equal(t, "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:", d.Value())
+
+	// Test: Several algorithms in one field
+	d, err = NewDigester(SHA256, SHA512)
+	require.NoError(t, err)
+	d.Write([]byte("hello world"))
+	assert.Equal(t, "sha-256=:uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek=:, "+
+		"sha-512=:MJ7MSJwS1utMxA9QyQLytNDtd+5RGnx6m808qG1M2G+YndNbxf9JlnDaNCVbRbDP2DDoH2Bdz33FVC6TrpzXbw==:", d.Value())
+
+	// Test: Unsupported algorithm
+	_, err = NewDigester("md5")
+	require.Error(t, err)
+}
+
+func TestParse(t *testing.T) {
+	// Test: Round trip, parameters ignored
+	d, err := NewDigester(SHA256, SHA512)
+	require.NoError(t, err)
+	d.Write([]byte("hello world"))
+	digests, err := Parse(d.Value() + ";param=1, unixsum=:AAAA:")
+	require.NoError(t, err)
+	assert.Len(t, digests, 3)
+	assert.Len(t, digests[SHA256], 32)
+	assert.Len(t, digests[SHA512], 64)
+
+	// Test: Malformed values
+	for _, value := range []string{"sha-256", "sha-256=abc", "sha-256=:not base64!:", "=:AAAA:"} {
+		_, err := Parse(value)
+		require.ErrorIs(t, err, ErrMalformed, value)
+	}
+}
@@ -0,0 +1,179 @@
+// Package fileserver serves files from disk, whole or by byte range.
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/headers"
+	"github.com/DanilShapilov/httpfromtcp/internal/request"
+	"github.com/DanilShapilov/httpfromtcp/internal/response"
+)
+
+// TimeFormat is the IMF-fixdate format of HTTP dates, always in GMT.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+var (
+	errMalformedRange     = errors.New("malformed range")
+	errUnsatisfiableRange = errors.New("range not satisfiable")
+)
+
+// ServeFile answers a GET or HEAD request with the named file. A single
+// byte range is answered with 206 Partial Content, unless If-Range names
+// another version of the file; requests for several ranges get the whole
+// file. contentType is guessed from the extension when empty.
+//
+// The body goes through Writer.ReadFrom, so on plain TCP connections it is
+// sent with sendfile and never copied through user space.
+func ServeFile(w *response.Writer, req *request.Request, name, contentType string) {
+	method := req.RequestLine.Method
+	if method != "GET" && method != "HEAD" {
+		writeError(w, response.StatusCodeMethodNotAllowed, headers.Headers{"allow": "GET, HEAD"})
+		return
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		writeError(w, statusForError(err), nil)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		writeError(w, response.StatusCodeInternalServerError, nil)
+		return
+	}
+	if info.IsDir() {
+		writeError(w, response.StatusCodeNotFound, nil)
+		return
+	}
+
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(name))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	size := info.Size()
+	lastModified := info.ModTime().UTC().Format(TimeFormat)
+	h := response.GetDefaultHeaders(0)
+	h.Override("Content-Type", contentType)
+	h.Override("Accept-Ranges", "bytes")
+	h.Override("Last-Modified", lastModified)
+
+	status := response.StatusCodeSuccess
+	start, length := int64(0), size
+	if rangeValue, exists := req.Headers.Get("range"); exists && ifRangeMatches(req, lastModified) {
+		rangeStart, rangeLength, err := parseRange(rangeValue, size)
+		switch {
+		case err == nil:
+			status = response.StatusCodePartialContent
+			start, length = rangeStart, rangeLength
+			h.Override("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, size))
+		case errors.Is(err, errUnsatisfiableRange):
+			writeError(w, response.StatusCodeRangeNotSatisfiable, headers.Headers{
+				"content-range": fmt.Sprintf("bytes */%d", size),
+			})
+			return
+		}
+		// a malformed Range is ignored, as if it wasn't sent
+	}
+	h.Override("Content-Length", strconv.FormatInt(length, 10))
+
+	if err := w.WriteStatusLine(status); err != nil {
+		return
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return
+	}
+	if method == "HEAD" {
+		return
+	}
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		return
+	}
+	w.ReadFrom(io.LimitReader(f, length))
+}
+
+// ifRangeMatches reports whether the Range header applies: there is no
+// If-Range, or it carries the file's Last-Modified date exactly. We don't
+// generate entity tags, so If-Range with one never matches.
+func ifRangeMatches(req *request.Request, lastModified string) bool {
+	ifRange, exists := req.Headers.Get("if-range")
+	if !exists {
+		return true
+	}
+	return ifRange == lastModified
+}
+
+// parseRange returns the byte range a Range value asks of a file of size
+// bytes (RFC 9110 section 14.1.2). Several ranges are reported as
+// malformed, the caller then serves the whole file.
+func parseRange(value string, size int64) (start, length int64, err error) {
+	unit, spec, ok := strings.Cut(value, "=")
+	if !ok || !strings.EqualFold(strings.TrimSpace(unit), "bytes") || strings.Contains(spec, ",") {
+		return 0, 0, errMalformedRange
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, errMalformedRange
+	}
+
+	if first == "" {
+		// a suffix range asks for the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, errMalformedRange
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, errUnsatisfiableRange
+		}
+		n = min(n, size)
+		return size - n, n, nil
+	}
+
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, errMalformedRange
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, errMalformedRange
+		}
+	}
+	if start >= size {
+		return 0, 0, errUnsatisfiableRange
+	}
+	end = min(end, size-1)
+	return start, end - start + 1, nil
+}
+
+func statusForError(err error) response.StatusCode {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return response.StatusCodeNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return response.StatusCodeForbidden
+	}
+	return response.StatusCodeInternalServerError
+}
+
+// writeError answers with the reason phrase as body and the extra headers.
+func writeError(w *response.Writer, statusCode response.StatusCode, extra headers.Headers) {
+	body := []byte(response.ReasonPhrase(statusCode))
+	h := response.GetDefaultHeaders(len(body))
+	for key, value := range extra {
+		h.Override(key, value)
+	}
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+}
@@ -0,0 +1,142 @@
+package fileserver
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/metrics"
+	"github.com/DanilShapilov/httpfromtcp/internal/request"
+	"github.com/DanilShapilov/httpfromtcp/internal/response"
+	"github.com/DanilShapilov/httpfromtcp/internal/server"
+	"github.com/DanilShapilov/httpfromtcp/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const content = "0123456789abcdefghij"
+
+var modTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func testFile(t *testing.T) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "data.txt")
+	require.NoError(t, os.WriteFile(name, []byte(content), 0o644))
+	require.NoError(t, os.Chtimes(name, modTime, modTime))
+	return name
+}
+
+// serve runs ServeFile for a request with the extra header lines and parses
+// what it wrote.
+func serve(t *testing.T, name, method, extra string) *response.Response {
+	t.Helper()
+	req := servertest.NewRequest(method, "/data.txt", nil)
+	for _, line := range strings.Split(strings.TrimSuffix(extra, "\r\n"), "\r\n") {
+		if line != "" {
+			require.NoError(t, req.Headers.ParseLine(line))
+		}
+	}
+	rr := servertest.NewRecorder()
+	rr.RequestMethod = method
+	ServeFile(rr.Writer, req, name, "")
+	res, err := rr.Result()
+	require.NoError(t, err)
+	return res
+}
+
+func header(res *response.Response, key string) string {
+	value, _ := res.Headers.Get(key)
+	return value
+}
+
+func TestServeFile(t *testing.T) {
+	name := testFile(t)
+
+	// Test: Whole file
+	res := serve(t, name, "GET", "")
+	assert.Equal(t, response.StatusCodeSuccess, res.StatusLine.StatusCode)
+	assert.Equal(t, content, string(res.Body))
+	assert.Equal(t, "bytes", header(res, "accept-ranges"))
+	assert.Equal(t, "Wed, 01 May 2024 12:00:00 GMT", header(res, "last-modified"))
+	assert.True(t, strings.HasPrefix(header(res, "content-type"), "text/plain"))
+
+	// Test: HEAD sends the headers only
+	res = serve(t, name, "HEAD", "")
+	assert.Equal(t, "20", header(res, "content-length"))
+	assert.Empty(t, res.Body)
+
+	// Test: Byte ranges
+	cases := []struct {
+		rangeValue   string
+		body         string
+		contentRange string
+	}{
+		{"bytes=2-5", "2345", "bytes 2-5/20"},
+		{"bytes=15-", "fghij", "bytes 15-19/20"},
+		{"bytes=-3", "hij", "bytes 17-19/20"},
+		{"bytes=10-99", "abcdefghij", "bytes 10-19/20"},
+		{"bytes=-50", content, "bytes 0-19/20"},
+	}
+	for _, tc := range cases {
+		res = serve(t, name, "GET", "Range: "+tc.rangeValue+"\r\n")
+		assert.Equal(t, response.StatusCodePartialContent, res.StatusLine.StatusCode, tc.rangeValue)
+		assert.Equal(t, tc.body, string(res.Body), tc.rangeValue)
+		assert.Equal(t, tc.contentRange, header(res, "content-range"), tc.rangeValue)
+	}
+
+	// Test: Unsatisfiable ranges
+	for _, rangeValue := range []string{"bytes=20-", "bytes=-0"} {
+		res = serve(t, name, "GET", "Range: "+rangeValue+"\r\n")
+		assert.Equal(t, response.StatusCodeRangeNotSatisfiable, res.StatusLine.StatusCode, rangeValue)
+		assert.Equal(t, "bytes */20", header(res, "content-range"), rangeValue)
+	}
+
+	// Test: Malformed and multiple ranges get the whole file
+	for _, rangeValue := range []string{"bytes=5-2", "lines=1-2", "bytes=abc", "bytes=0-1,4-5"} {
+		res = serve(t, name, "GET", "Range: "+rangeValue+"\r\n")
+		assert.Equal(t, response.StatusCodeSuccess, res.StatusLine.StatusCode, rangeValue)
+		assert.Equal(t, content, string(res.Body), rangeValue)
+	}
+
+	// Test: If-Range with the current date keeps the range, anything else drops it
+	res = serve(t, name, "GET", "Range: bytes=0-1\r\nIf-Range: Wed, 01 May 2024 12:00:00 GMT\r\n")
+	assert.Equal(t, response.StatusCodePartialContent, res.StatusLine.StatusCode)
+	res = serve(t, name, "GET", "Range: bytes=0-1\r\nIf-Range: Tue, 30 Apr 2024 12:00:00 GMT\r\n")
+	assert.Equal(t, response.StatusCodeSuccess, res.StatusLine.StatusCode)
+	res = serve(t, name, "GET", "Range: bytes=0-1\r\nIf-Range: \"v1\"\r\n")
+	assert.Equal(t, response.StatusCodeSuccess, res.StatusLine.StatusCode)
+
+	// Test: Errors
+	res = serve(t, filepath.Join(t.TempDir(), "missing.txt"), "GET", "")
+	assert.Equal(t, response.StatusCodeNotFound, res.StatusLine.StatusCode)
+	res = serve(t, t.TempDir(), "GET", "")
+	assert.Equal(t, response.StatusCodeNotFound, res.StatusLine.StatusCode)
+	res = serve(t, name, "POST", "Content-Length: 0\r\n")
+	assert.Equal(t, response.StatusCodeMethodNotAllowed, res.StatusLine.StatusCode)
+	assert.Equal(t, "GET, HEAD", header(res, "allow"))
+}
+
+func TestServeFileOverTCP(t *testing.T) {
+	name := testFile(t)
+	// with metrics the connection is wrapped to count bytes
+	for _, registry := range []*metrics.Registry{nil, metrics.NewRegistry()} {
+		srv := servertest.NewTestServerConfig(server.Config{Metrics: registry}, func(w *response.Writer, req *request.Request) {
+			ServeFile(w, req, name, "text/plain")
+		})
+		defer srv.Close()
+
+		// Test: Ranges are sent from the file straight to the connection
+		conn, err := net.Dial("tcp", srv.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		fmt.Fprintf(conn, "GET /data.txt HTTP/1.1\r\nHost: localhost\r\nRange: bytes=4-9\r\n\r\n")
+		res, err := response.ResponseFromReader(conn)
+		require.NoError(t, err)
+		assert.Equal(t, response.StatusCodePartialContent, res.StatusLine.StatusCode)
+		assert.Equal(t, "456789", string(res.Body))
+	}
+}
@@ -3,6 +3,7 @@ package headers
 import (
 	"bytes"
 	"fmt"
+	"iter"
 	"strings"
 )
 
@@ -14,22 +15,33 @@ func NewHeaders() Headers {
 
 const crlf = "\r\n"
 
-var allowedSpecialChars = map[byte]struct{}{
-	'!':  {},
-	'#':  {},
-	'$':  {},
-	'%':  {},
-	'&':  {},
-	'\'': {},
-	'*':  {},
-	'+':  {},
-	'-':  {},
-	'.':  {},
-	'^':  {},
-	'_':  {},
-	'`':  {},
-	'|':  {},
-	'~':  {},
+// tokenChars marks the bytes allowed in a field name (RFC 9110 section 5.6.2).
+var tokenChars = func() (t [256]bool) {
+	for c := '0'; c <= '9'; c++ {
+		t[c] = true
+	}
+	for c := 'a'; c <= 'z'; c++ {
+		t[c] = true
+		t[c-'a'+'A'] = true
+	}
+	for _, c := range []byte("!#$%&'*+-.^_`|~") {
+		t[c] = true
+	}
+	return t
+}()
+
+// IsToken reports whether s is a token, the syntax of field names and of
+// several other protocol elements like cookie names.
+func IsToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !tokenChars[s[i]] {
+			return false
+		}
+	}
+	return true
 }
 
 func (h Headers) Parse(data []byte) (n int, done bool, err error) {
@@ -42,36 +54,136 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 		// headers are done, consume the CRLF
 		return 2, true, nil
 	}
-	headerLineText := string(data[:idx])
-	parts := strings.SplitN(headerLineText, ":", 2)
-	if len(parts) != 2 {
-		return 0, false, fmt.Errorf("error: incorrect headers format '%s'", headerLineText)
+	key, value, err := splitLine(data[:idx])
+	if err != nil {
+		return 0, false, err
 	}
-	key := parts[0]
-	if key != strings.TrimRight(key, " ") {
-		return 0, false, fmt.Errorf("invalid header name: '%s'", key)
+	h.Set(internKey(key), string(value))
+	bytesConsumed := idx + len(crlf)
+
+	return bytesConsumed, false, nil
+}
+
+// ParseLine adds a single field line, without its CRLF. Values are
+// substrings of line, so a whole header section converted to a string once
+// can be parsed without further copies.
+func (h Headers) ParseLine(line string) error {
+	key, value, err := splitLine(line)
+	if err != nil {
+		return err
 	}
-	key = strings.TrimSpace(key)
-	key = strings.ToLower(key)
-	value := strings.TrimSpace(parts[1])
+	h.Set(internKey(key), value)
+	return nil
+}
 
-	if !validTokens([]byte(key)) {
-		return 0, false, fmt.Errorf("invalid header token found: '%s'", key)
+// splitLine splits a field line into its name and value, both trimmed.
+// Whitespace before the colon is not allowed, whitespace before the name
+// is tolerated.
+func splitLine[T string | []byte](line T) (key, value T, err error) {
+	colon := -1
+	for i := 0; i < len(line); i++ {
+		if line[i] == ':' {
+			colon = i
+			break
+		}
+	}
+	if colon == -1 {
+		return key, value, fmt.Errorf("error: incorrect headers format '%s'", line)
+	}
+	key = line[:colon]
+	if len(key) > 0 && isSpace(key[len(key)-1]) {
+		return key, value, fmt.Errorf("invalid header name: '%s'", key)
 	}
+	key = trimSpace(key)
+	if len(key) == 0 {
+		return key, value, fmt.Errorf("invalid header token found: '%s'", key)
+	}
+	for i := 0; i < len(key); i++ {
+		if !tokenChars[key[i]] {
+			return key, value, fmt.Errorf("invalid header token found: '%s'", key)
+		}
+	}
+	value = trimSpace(line[colon+1:])
+	// a bare CR or LF would be a line break to other parsers
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; c == '\r' || c == '\n' || c == 0 {
+			return key, value, fmt.Errorf("invalid character in value of header '%s'", key)
+		}
+	}
+	return key, value, nil
+}
 
-	h.Set(key, value)
-	bytesConsumed := idx + len(crlf)
+func trimSpace[T string | []byte](s T) T {
+	start, end := 0, len(s)
+	for start < end && isSpace(s[start]) {
+		start++
+	}
+	for end > start && isSpace(s[end-1]) {
+		end--
+	}
+	return s[start:end]
+}
 
-	return bytesConsumed, false, nil
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t'
 }
 
+// Set adds value to key. Repeated fields are combined into one value with
+// a comma, except for Set-Cookie whose lines are kept apart (RFC 9110
+// section 5.3) and Cookie, which is combined with "; " (RFC 9113 section
+// 8.2.3). Use Values or All to get the lines of Set-Cookie back.
 func (h Headers) Set(key, value string) {
 	key = strings.ToLower(key)
 	v, exists := h[key]
-	if exists {
-		h[key] = fmt.Sprintf("%s, %s", v, value)
-	} else {
+	// empty list members mean nothing (RFC 9110 section 5.6.1), joining
+	// them would give values like ", " that don't survive a round trip
+	switch {
+	case !exists || v == "":
 		h[key] = value
+	case value != "":
+		h[key] = v + separator(key) + value
+	}
+}
+
+// lineSep separates the lines of a field that can't be combined. Parsed
+// values never contain it.
+const lineSep = "\n"
+
+func separator(key string) string {
+	switch key {
+	case "set-cookie":
+		return lineSep
+	case "cookie":
+		return "; "
+	}
+	return ", "
+}
+
+// Values returns the lines of key, several only for Set-Cookie.
+func (h Headers) Values(key string) []string {
+	v, exists := h.Get(key)
+	if !exists {
+		return nil
+	}
+	return strings.Split(v, lineSep)
+}
+
+// All iterates over the field lines as they go on the wire, one per
+// Set-Cookie.
+func (h Headers) All() iter.Seq2[string, string] {
+	return func(yield func(key, value string) bool) {
+		for key, value := range h {
+			for {
+				line, rest, more := strings.Cut(value, lineSep)
+				if !yield(key, line) {
+					return
+				}
+				if !more {
+					break
+				}
+				value = rest
+			}
+		}
 	}
 }
 
@@ -90,22 +202,3 @@ func (h Headers) Get(key string) (string, bool) {
 	v, exists := h[key]
 	return v, exists
 }
-
-func validTokens(data []byte) bool {
-	for _, c := range data {
-		if !isTokenChar(c) {
-			return false
-		}
-	}
-	return true
-}
-
-func isTokenChar(c byte) bool {
-	if c >= '0' && c <= '9' ||
-		c >= 'a' && c <= 'z' ||
-		c >= 'A' && c <= 'Z' {
-		return true
-	}
-	_, exists := allowedSpecialChars[c]
-	return exists
-}
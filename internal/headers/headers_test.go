@@ -1,6 +1,7 @@
 package headers
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -102,3 +103,132 @@ func TestHeadersParse(t *testing.T) {
 	assert.False(t, done)
 
 }
+
+func TestParseLine(t *testing.T) {
+	// Test: Values share the line, common names are interned
+	headers := NewHeaders()
+	require.NoError(t, headers.ParseLine("Content-Type:  text/html\t"))
+	require.NoError(t, headers.ParseLine("x-lower: yes"))
+	require.NoError(t, headers.ParseLine("X-Mixed-Case: yes"))
+	assert.Equal(t, "text/html", headers["content-type"])
+	assert.Equal(t, "yes", headers["x-lower"])
+	assert.Equal(t, "yes", headers["x-mixed-case"])
+
+	// Test: Malformed lines
+	require.Error(t, headers.ParseLine("no colon"))
+	require.Error(t, headers.ParseLine(": empty name"))
+	require.Error(t, headers.ParseLine("Bad Name: value"))
+	require.Error(t, headers.ParseLine("X-Split: a\nX-Injected: b"))
+	require.Error(t, headers.ParseLine("X-Split: a\rb"))
+	require.Error(t, headers.ParseLine("X-Nul: a\x00b"))
+
+	// Test: Empty values are not joined
+	require.NoError(t, headers.ParseLine("X-Empty:"))
+	require.NoError(t, headers.ParseLine("X-Empty: a"))
+	require.NoError(t, headers.ParseLine("X-Empty:"))
+	assert.Equal(t, "a", headers["x-empty"])
+
+	// Test: Interned names don't allocate
+	allocs := testing.AllocsPerRun(100, func() {
+		headers.ParseLine("Accept-Encoding: gzip")
+		delete(headers, "accept-encoding")
+	})
+	assert.Zero(t, allocs)
+}
+
+func TestValues(t *testing.T) {
+	// Test: Set-Cookie lines are kept apart, commas and all
+	headers := NewHeaders()
+	headers.Set("Set-Cookie", "a=1; Expires=Wed, 01 May 2024 12:00:00 GMT")
+	headers.Set("Set-Cookie", "b=2")
+	assert.Equal(t, []string{"a=1; Expires=Wed, 01 May 2024 12:00:00 GMT", "b=2"}, headers.Values("set-cookie"))
+
+	// Test: Cookies are combined with semicolons, other fields with commas
+	headers.Set("Cookie", "c=3")
+	headers.Set("Cookie", "d=4")
+	headers.Set("Accept", "text/html")
+	headers.Set("Accept", "*/*")
+	assert.Equal(t, []string{"c=3; d=4"}, headers.Values("cookie"))
+	assert.Equal(t, []string{"text/html, */*"}, headers.Values("accept"))
+	assert.Nil(t, headers.Values("x-missing"))
+
+	// Test: All yields one line per Set-Cookie
+	var lines []string
+	for key, value := range headers.All() {
+		lines = append(lines, key+": "+value)
+	}
+	assert.ElementsMatch(t, []string{
+		"set-cookie: a=1; Expires=Wed, 01 May 2024 12:00:00 GMT",
+		"set-cookie: b=2",
+		"cookie: c=3; d=4",
+		"accept: text/html, */*",
+	}, lines)
+}
+
+func BenchmarkParse(b *testing.B) {
+	data := []byte("Host: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\nX-Request-Id: 42\r\n\r\n")
+	b.ReportAllocs()
+	for range b.N {
+		h := NewHeaders()
+		for n, done := 0, false; !done; {
+			read, isDone, err := h.Parse(data[n:])
+			if err != nil {
+				b.Fatal(err)
+			}
+			n += read
+			done = isDone
+		}
+	}
+}
+
+func FuzzHeadersParse(f *testing.F) {
+	for _, seed := range []string{
+		"Host: localhost:42069\r\n\r\n",
+		"    Host:     localhost:42069    \r\n Token: 123qweasd345 \r\n\r\n",
+		"       Host : localhost:42069       \r\n\r\n",
+		"H©st: localhost:42069\r\n\r\n",
+		"Set-Person: lane-loves-go\r\nSet-Person: prime-loves-zig\r\n\r\n",
+		"Set-Cookie: a=1\r\nSet-Cookie: b=2, c=3\r\nCookie: d=4\r\nCookie: e=5\r\n\r\n",
+		"X-Empty:\r\nX-Empty:\r\n\r\n",
+		"X-Split: a\nb\r\n\r\n",
+		"X-Nul: a\x00b\r\n\r\n",
+		"X-Tab:\tvalue\t\r\n\r\n",
+		": no name\r\n\r\n",
+		"no colon\r\n\r\n",
+		"Host: localhost",
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		h := NewHeaders()
+		lines := NewHeaders()
+		total := 0
+		for total < len(data) {
+			n, done, err := h.Parse(data[total:])
+			if err != nil || n == 0 {
+				break
+			}
+			require.LessOrEqual(t, total+n, len(data))
+			if !done {
+				line := string(data[total : total+n-len(crlf)])
+				require.NoError(t, lines.ParseLine(line), "Parse accepted %q", line)
+			}
+			total += n
+			if done {
+				break
+			}
+		}
+
+		// Parse and ParseLine agree
+		assert.Equal(t, lines, h)
+		for key, value := range h.All() {
+			assert.NotEmpty(t, key)
+			assert.Equal(t, strings.ToLower(key), key)
+			for i := 0; i < len(key); i++ {
+				assert.True(t, tokenChars[key[i]], "key %q", key)
+			}
+			assert.Equal(t, strings.Trim(value, " \t"), value, "value %q", value)
+			assert.False(t, strings.ContainsAny(value, "\r\n\x00"), "value %q", value)
+		}
+	})
+}
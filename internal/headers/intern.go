@@ -0,0 +1,56 @@
+package headers
+
+import "strings"
+
+// commonKeys holds the lowercased names of fields seen on most requests and
+// responses, so parsing them doesn't allocate a new string each time.
+var commonKeys = func() map[string]string {
+	m := make(map[string]string)
+	for _, key := range []string{
+		"accept", "accept-charset", "accept-encoding", "accept-language", "accept-ranges",
+		"access-control-request-headers", "access-control-request-method",
+		"age", "allow", "authorization", "cache-control", "connection",
+		"content-digest", "content-disposition", "content-encoding", "content-language",
+		"content-length", "content-location", "content-range", "content-type",
+		"cookie", "date", "dnt", "etag", "expect", "expires", "forwarded", "host",
+		"if-match", "if-modified-since", "if-none-match", "if-range", "if-unmodified-since",
+		"keep-alive", "last-event-id", "last-modified", "location", "origin", "pragma",
+		"priority", "proxy-authorization", "range", "referer", "repr-digest", "retry-after",
+		"sec-ch-ua", "sec-ch-ua-mobile", "sec-ch-ua-platform",
+		"sec-fetch-dest", "sec-fetch-mode", "sec-fetch-site", "sec-fetch-user",
+		"sec-websocket-accept", "sec-websocket-extensions", "sec-websocket-key",
+		"sec-websocket-protocol", "sec-websocket-version",
+		"server", "set-cookie", "te", "traceparent", "tracestate", "trailer",
+		"transfer-encoding", "upgrade", "upgrade-insecure-requests", "user-agent",
+		"vary", "via", "www-authenticate", "x-forwarded-for", "x-forwarded-host",
+		"x-forwarded-proto", "x-real-ip", "x-request-id",
+	} {
+		m[key] = key
+	}
+	return m
+}()
+
+// maxCommonKeyLen is the longest name in commonKeys.
+const maxCommonKeyLen = len("access-control-request-headers")
+
+// internKey returns key lowercased, without allocating for common names or
+// for string keys that are lowercase already.
+func internKey[T string | []byte](key T) string {
+	if len(key) <= maxCommonKeyLen {
+		var lower [maxCommonKeyLen]byte
+		for i := 0; i < len(key); i++ {
+			lower[i] = toLower(key[i])
+		}
+		if common, ok := commonKeys[string(lower[:len(key)])]; ok {
+			return common
+		}
+	}
+	return strings.ToLower(string(key))
+}
+
+func toLower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
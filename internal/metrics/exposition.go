@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/request"
+	"github.com/DanilShapilov/httpfromtcp/internal/response"
+)
+
+// ContentType is the media type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// WriteText renders every family in the Prometheus text exposition format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	families := append([]*family(nil), r.families...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		bw.WriteString("# HELP " + f.name + " " + escapeHelp(f.help) + "\n")
+		bw.WriteString("# TYPE " + f.name + " " + string(f.typ) + "\n")
+		for _, s := range f.snapshot() {
+			if f.typ != typeHistogram {
+				writeSample(bw, f.name, f.labels, s.labelValues, "", "", s.value.load())
+				continue
+			}
+			var cumulative uint64
+			for i, upper := range f.buckets {
+				cumulative += s.bucketCounts[i].Load()
+				writeSample(bw, f.name+"_bucket", f.labels, s.labelValues, "le", formatFloat(upper), float64(cumulative))
+			}
+			count := s.count.Load()
+			writeSample(bw, f.name+"_bucket", f.labels, s.labelValues, "le", "+Inf", float64(count))
+			writeSample(bw, f.name+"_sum", f.labels, s.labelValues, "", "", s.sum.load())
+			writeSample(bw, f.name+"_count", f.labels, s.labelValues, "", "", float64(count))
+		}
+	}
+	return bw.Flush()
+}
+
+// writeSample writes one `name{labels} value` line, with an optional extra
+// label such as a histogram's le.
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraLabel, extraValue string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraLabel != "" {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(label + `="` + escapeLabelValue(values[i]) + `"`)
+		}
+		if extraLabel != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(extraLabel + `="` + extraValue + `"`)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string       { return helpEscaper.Replace(s) }
+func escapeLabelValue(s string) string { return labelValueEscaper.Replace(s) }
+
+// Handler returns a handler that serves the registry, meant to be mounted
+// at /metrics.
+func Handler(r *Registry) func(w *response.Writer, req *request.Request) {
+	return func(w *response.Writer, _ *request.Request) {
+		var b strings.Builder
+		if err := r.WriteText(&b); err != nil {
+			body := []byte(err.Error())
+			w.WriteStatusLine(response.StatusCodeInternalServerError)
+			w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+			w.WriteBody(body)
+			return
+		}
+		body := []byte(b.String())
+		h := response.GetDefaultHeaders(len(body))
+		h.Override("Content-Type", ContentType)
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		w.WriteHeaders(h)
+		w.WriteBody(body)
+	}
+}
@@ -0,0 +1,202 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefBuckets are the default histogram buckets, in seconds. They are the
+// same as the Prometheus client libraries use and fit request latencies.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metricType string
+
+const (
+	typeCounter   metricType = "counter"
+	typeGauge     metricType = "gauge"
+	typeHistogram metricType = "histogram"
+)
+
+// Registry holds metric families and renders them in the Prometheus text
+// exposition format. Families are written in registration order.
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+	names    map[string]struct{}
+}
+
+func NewRegistry() *Registry {
+	return &Registry{names: map[string]struct{}{}}
+}
+
+// family is all series of one metric name, keyed by joined label values.
+type family struct {
+	name    string
+	help    string
+	typ     metricType
+	labels  []string
+	buckets []float64 // histograms only
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       atomicFloat // counters and gauges
+
+	// histograms only
+	bucketCounts []atomic.Uint64
+	count        atomic.Uint64
+	sum          atomicFloat
+}
+
+func (r *Registry) register(f *family) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.names[f.name]; exists {
+		panic(fmt.Sprintf("metrics: %s registered twice", f.name))
+	}
+	r.names[f.name] = struct{}{}
+	f.series = map[string]*series{}
+	r.families = append(r.families, f)
+	return f
+}
+
+// with returns the series for the label values, creating it on first use.
+func (f *family) with(labelValues []string) *series {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, exists := f.series[key]
+	if !exists {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if f.typ == typeHistogram {
+			s.bucketCounts = make([]atomic.Uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// snapshot returns the series sorted by label values for stable output.
+func (f *family) snapshot() []*series {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	all := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		all = append(all, s)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return strings.Join(all[i].labelValues, "\xff") < strings.Join(all[j].labelValues, "\xff")
+	})
+	return all
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct{ f *family }
+
+// Counter only goes up.
+type Counter struct{ s *series }
+
+func (r *Registry) NewCounter(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.register(&family{name: name, help: help, typ: typeCounter, labels: labels})}
+}
+
+func (v *CounterVec) With(labelValues ...string) *Counter {
+	return &Counter{v.f.with(labelValues)}
+}
+
+func (c *Counter) Inc() { c.s.value.add(1) }
+
+// Add increases the counter by delta, which must not be negative.
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	c.s.value.add(delta)
+}
+
+func (c *Counter) Value() float64 { return c.s.value.load() }
+
+// GaugeVec is a gauge partitioned by labels.
+type GaugeVec struct{ f *family }
+
+// Gauge goes up and down.
+type Gauge struct{ s *series }
+
+func (r *Registry) NewGauge(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{r.register(&family{name: name, help: help, typ: typeGauge, labels: labels})}
+}
+
+func (v *GaugeVec) With(labelValues ...string) *Gauge {
+	return &Gauge{v.f.with(labelValues)}
+}
+
+func (g *Gauge) Set(value float64) { g.s.value.store(value) }
+func (g *Gauge) Add(delta float64) { g.s.value.add(delta) }
+func (g *Gauge) Inc()              { g.s.value.add(1) }
+func (g *Gauge) Dec()              { g.s.value.add(-1) }
+func (g *Gauge) Value() float64    { return g.s.value.load() }
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct{ f *family }
+
+// Histogram counts observations into cumulative buckets.
+type Histogram struct {
+	s       *series
+	buckets []float64
+}
+
+// NewHistogram registers a histogram with the given upper bounds, which must
+// be sorted. A nil buckets means DefBuckets. The +Inf bucket is implicit.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("metrics: %s buckets are not sorted", name))
+	}
+	return &HistogramVec{r.register(&family{name: name, help: help, typ: typeHistogram, labels: labels, buckets: buckets})}
+}
+
+func (v *HistogramVec) With(labelValues ...string) *Histogram {
+	return &Histogram{s: v.f.with(labelValues), buckets: v.f.buckets}
+}
+
+func (h *Histogram) Observe(value float64) {
+	// buckets are stored non-cumulative and summed up when rendering
+	i := sort.SearchFloat64s(h.buckets, value)
+	if i < len(h.buckets) {
+		h.s.bucketCounts[i].Add(1)
+	}
+	h.s.count.Add(1)
+	h.s.sum.add(value)
+}
+
+func (h *Histogram) Count() uint64 { return h.s.count.Load() }
+func (h *Histogram) Sum() float64  { return h.s.sum.load() }
+
+// atomicFloat is a float64 updated with compare-and-swap on its bits.
+type atomicFloat struct{ bits atomic.Uint64 }
+
+func (f *atomicFloat) load() float64 { return math.Float64frombits(f.bits.Load()) }
+
+func (f *atomicFloat) store(v float64) { f.bits.Store(math.Float64bits(v)) }
+
+func (f *atomicFloat) add(delta float64) {
+	for {
+		old := f.bits.Load()
+		next := math.Float64bits(math.Float64frombits(old) + delta)
+		if f.bits.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/request"
+	"github.com/DanilShapilov/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteText(t *testing.T) {
+	reg := NewRegistry()
+	requests := reg.NewCounter("requests_total", "Requests.", "method", "status")
+	active := reg.NewGauge("active", "Active things.")
+	latency := reg.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1})
+
+	requests.With("GET", "200").Inc()
+	requests.With("GET", "200").Add(2)
+	requests.With("POST", "500").Inc()
+	active.With().Inc()
+	active.With().Inc()
+	active.With().Dec()
+	latency.With().Observe(0.05)
+	latency.With().Observe(0.1)
+	latency.With().Observe(3)
+
+	// Test: Families in registration order, series sorted, cumulative buckets
+	var b strings.Builder
+	require.NoError(t, reg.WriteText(&b))
+	assert.Equal(t, `# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{method="GET",status="200"} 3
+requests_total{method="POST",status="500"} 1
+# HELP active Active things.
+# TYPE active gauge
+active 1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 2
+latency_seconds_bucket{le="1"} 2
+latency_seconds_bucket{le="+Inf"} 3
+latency_seconds_sum 3.15
+latency_seconds_count 3
+`, b.String())
+
+	// Test: Label values are escaped
+	reg = NewRegistry()
+	reg.NewCounter("paths_total", "Paths with \\ and\nnewlines.", "path").With("/a\"b\\c\n").Inc()
+	b.Reset()
+	require.NoError(t, reg.WriteText(&b))
+	assert.Equal(t, `# HELP paths_total Paths with \\ and\nnewlines.
+# TYPE paths_total counter
+paths_total{path="/a\"b\\c\n"} 1
+`, b.String())
+
+	// Test: Duplicate registration and wrong label count panic
+	assert.Panics(t, func() { reg.NewGauge("paths_total", "again") })
+	assert.Panics(t, func() { requests.With("GET") })
+	assert.Panics(t, func() { requests.With("GET", "200").Add(-1) })
+}
+
+func TestHandler(t *testing.T) {
+	reg := NewRegistry()
+	reg.NewCounter("hits_total", "Hits.").With().Inc()
+
+	// Test: Served with the exposition content type
+	pr, pw := io.Pipe()
+	go func() {
+		Handler(reg)(response.NewWriter(pw), &request.Request{})
+		pw.Close()
+	}()
+	res, err := io.ReadAll(pr)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(res), "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, string(res), "content-type: "+ContentType+"\r\n")
+	assert.True(t, strings.HasSuffix(string(res), "hits_total 1\n"))
+}
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Backend is one upstream in a Balancer pool along with what the balancer
+// knows about its health and load.
+type Backend struct {
+	URL *url.URL
+
+	active atomic.Int64 // requests in flight
+	// healthy is the verdict of the last active health check, true until a
+	// check says otherwise
+	healthy atomic.Bool
+
+	mu           sync.Mutex
+	failures     int // consecutive failed requests
+	ejectedUntil time.Time
+}
+
+func newBackend(u *url.URL) *Backend {
+	b := &Backend{URL: u}
+	b.healthy.Store(true)
+	return b
+}
+
+// ActiveRequests returns the number of requests currently sent to b.
+func (b *Backend) ActiveRequests() int64 {
+	return b.active.Load()
+}
+
+// Available reports whether b passes health checks and is not ejected.
+func (b *Backend) Available(now time.Time) bool {
+	if !b.healthy.Load() {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !now.Before(b.ejectedUntil)
+}
+
+// recordSuccess resets the consecutive failure count.
+func (b *Backend) recordSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+}
+
+// recordFailure counts a failed request and ejects b for ejectFor once
+// maxFails failures happened in a row. It reports whether b got ejected.
+func (b *Backend) recordFailure(now time.Time, maxFails int, ejectFor time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if maxFails <= 0 || b.failures < maxFails {
+		return false
+	}
+	b.failures = 0
+	b.ejectedUntil = now.Add(ejectFor)
+	return true
+}
@@ -0,0 +1,227 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/client"
+	"github.com/DanilShapilov/httpfromtcp/internal/request"
+	"github.com/DanilShapilov/httpfromtcp/internal/response"
+)
+
+// HealthCheck configures active health checks: every Interval each backend
+// gets a GET for Path, and is taken out of rotation until it answers with a
+// status below 500 again.
+type HealthCheck struct {
+	Path     string
+	Interval time.Duration
+	// Timeout bounds each check. Defaults to Interval.
+	Timeout time.Duration
+}
+
+// Balancer is a reverse proxy that spreads requests over a pool of
+// backends.
+type Balancer struct {
+	Backends []*Backend
+	// Strategy picks a backend per request. Defaults to RoundRobin.
+	Strategy Strategy
+
+	// MaxFails consecutive failures, transport errors or 5xx responses,
+	// eject a backend for EjectFor. Zero disables passive ejection.
+	MaxFails int
+	EjectFor time.Duration
+	// Retries is how many other backends an idempotent request is sent to
+	// when a backend can't be reached.
+	Retries int
+
+	HealthCheck HealthCheck
+
+	// StripPrefix, Client and ErrorLog work as in ReverseProxy.
+	StripPrefix string
+	Client      *client.Client
+	ErrorLog    *log.Logger
+}
+
+// NewBalancer returns a Balancer over the upstream base URLs in targets.
+func NewBalancer(targets []string, strategy Strategy) (*Balancer, error) {
+	if len(targets) == 0 {
+		return nil, errors.New("proxy: no backends")
+	}
+	b := &Balancer{Strategy: strategy}
+	for _, target := range targets {
+		p, err := New(target)
+		if err != nil {
+			return nil, err
+		}
+		b.Backends = append(b.Backends, newBackend(p.Target))
+	}
+	return b, nil
+}
+
+// pick returns a backend for req that is not in tried, or nil.
+func (b *Balancer) pick(req *request.Request, tried map[*Backend]bool) *Backend {
+	now := time.Now()
+	candidates := make([]*Backend, 0, len(b.Backends))
+	for _, backend := range b.Backends {
+		if !tried[backend] && backend.Available(now) {
+			candidates = append(candidates, backend)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	strategy := b.Strategy
+	if strategy == nil {
+		strategy = defaultStrategy
+	}
+	return strategy.Pick(candidates, req)
+}
+
+var defaultStrategy = RoundRobin()
+
+// Handle proxies req to one of the backends. It has the server.Handler
+// signature.
+func (b *Balancer) Handle(w *response.Writer, req *request.Request) {
+	attempts := 1
+	if client.IsIdempotent(req.RequestLine.Method) {
+		attempts += b.Retries
+	}
+	tried := map[*Backend]bool{}
+
+	for attempt := 0; attempt < attempts; attempt++ {
+		backend := b.pick(req, tried)
+		if backend == nil {
+			if attempt == 0 {
+				// the whole pool is down or ejected
+				writeError(w, response.StatusCodeServiceUnavailable)
+				return
+			}
+			break
+		}
+		tried[backend] = true
+
+		res, err := b.roundTrip(backend, req)
+		if err != nil {
+			if req.Context().Err() != nil {
+				return
+			}
+			logf(b.ErrorLog, "proxy: %s %s via %s: %v", req.RequestLine.Method, req.RequestLine.RequestTarget, backend.URL, err)
+			continue
+		}
+		err = copyResponse(w, req, res)
+		res.Body.Close()
+		backend.active.Add(-1)
+		if err != nil && req.Context().Err() == nil {
+			logf(b.ErrorLog, "proxy: copying response from %s: %v", backend.URL, err)
+		}
+		return
+	}
+
+	writeError(w, response.StatusCodeBadGateway)
+}
+
+// roundTrip sends req to backend and records the outcome for passive
+// ejection. On success the backend's active count stays incremented until
+// the caller is done with the body.
+func (b *Balancer) roundTrip(backend *Backend, req *request.Request) (*client.Response, error) {
+	outReq, err := outgoingRequest(req, backend.URL, b.StripPrefix)
+	if err != nil {
+		return nil, err
+	}
+	backend.active.Add(1)
+	res, err := clientOrDefault(b.Client).Do(req.Context(), outReq)
+	if err != nil || res.StatusCode >= 500 {
+		if req.Context().Err() == nil && backend.recordFailure(time.Now(), b.MaxFails, b.EjectFor) {
+			logf(b.ErrorLog, "proxy: ejecting %s for %v", backend.URL, b.EjectFor)
+		}
+	} else {
+		backend.recordSuccess()
+	}
+	if err != nil {
+		backend.active.Add(-1)
+		return nil, err
+	}
+	return res, nil
+}
+
+// StartHealthChecks runs the configured active health checks in the
+// background until the returned stop function is called. A zero
+// HealthCheck.Interval makes it a no-op.
+func (b *Balancer) StartHealthChecks() (stop func()) {
+	if b.HealthCheck.Interval <= 0 {
+		return func() {}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(b.HealthCheck.Interval)
+		defer ticker.Stop()
+		for {
+			b.checkAll(ctx)
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func (b *Balancer) checkAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, backend := range b.Backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := b.check(ctx, backend)
+			if ctx.Err() != nil {
+				return
+			}
+			healthy := err == nil
+			if backend.healthy.Swap(healthy) != healthy {
+				if healthy {
+					logf(b.ErrorLog, "proxy: %s is healthy again", backend.URL)
+				} else {
+					logf(b.ErrorLog, "proxy: %s failed health check: %v", backend.URL, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (b *Balancer) check(ctx context.Context, backend *Backend) error {
+	timeout := b.HealthCheck.Timeout
+	if timeout <= 0 {
+		timeout = b.HealthCheck.Interval
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	u := *backend.URL
+	u.Path = singleJoiningSlash(backend.URL.Path, b.HealthCheck.Path)
+	req, err := client.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	res, err := clientOrDefault(b.Client).Do(ctx, req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+	if res.StatusCode >= 500 {
+		return fmt.Errorf("status %d", res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/headers"
+	"github.com/DanilShapilov/httpfromtcp/internal/request"
+	"github.com/DanilShapilov/httpfromtcp/internal/response"
+	"github.com/DanilShapilov/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testBackend is an upstream that answers with its name and whose health
+// endpoint can be switched off.
+type testBackend struct {
+	name    string
+	srv     *server.Server
+	healthy atomic.Bool
+}
+
+func startBackend(t *testing.T, name string) *testBackend {
+	t.Helper()
+	tb := &testBackend{name: name}
+	tb.healthy.Store(true)
+	srv, err := server.ServeConfig(server.Config{Addr: "127.0.0.1:0"}, func(w *response.Writer, req *request.Request) {
+		status, body := response.StatusCodeSuccess, []byte(tb.name)
+		if req.RequestLine.RequestTarget == "/health" && !tb.healthy.Load() {
+			status = response.StatusCodeServiceUnavailable
+		}
+		w.WriteStatusLine(status)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	})
+	require.NoError(t, err)
+	tb.srv = srv
+	t.Cleanup(func() { srv.Close() })
+	return tb
+}
+
+func (tb *testBackend) url() string {
+	return "http://" + tb.srv.Addr().String()
+}
+
+// startBalancer starts n backends named b0..bn-1 and a front server
+// balancing over them.
+func startBalancer(t *testing.T, n int, strategy Strategy) (*Balancer, []*testBackend, string) {
+	t.Helper()
+	var backends []*testBackend
+	var targets []string
+	for i := 0; i < n; i++ {
+		tb := startBackend(t, fmt.Sprintf("b%d", i))
+		backends = append(backends, tb)
+		targets = append(targets, tb.url())
+	}
+	b, err := NewBalancer(targets, strategy)
+	require.NoError(t, err)
+	b.ErrorLog = log.New(io.Discard, "", 0)
+	front, err := server.ServeConfig(server.Config{Addr: "127.0.0.1:0"}, b.Handle)
+	require.NoError(t, err)
+	t.Cleanup(func() { front.Close() })
+	return b, backends, "http://" + front.Addr().String()
+}
+
+func get(t *testing.T, method, url string, header ...string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	require.NoError(t, err)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return res.StatusCode, string(body)
+}
+
+func TestRoundRobin(t *testing.T) {
+	_, _, front := startBalancer(t, 3, RoundRobin())
+
+	// Test: Requests cycle through the pool
+	var names []string
+	for i := 0; i < 6; i++ {
+		_, body := get(t, "GET", front+"/")
+		names = append(names, body)
+	}
+	assert.Equal(t, "b0,b1,b2,b0,b1,b2", strings.Join(names, ","))
+}
+
+func TestLeastConnections(t *testing.T) {
+	backends := []*Backend{
+		newBackend(&url.URL{Host: "a"}),
+		newBackend(&url.URL{Host: "b"}),
+		newBackend(&url.URL{Host: "c"}),
+	}
+	s := LeastConnections()
+
+	// Test: Fewest in-flight requests wins, pool order breaks ties
+	assert.Equal(t, backends[0], s.Pick(backends, nil))
+	backends[0].active.Add(2)
+	backends[1].active.Add(1)
+	assert.Equal(t, backends[2], s.Pick(backends, nil))
+	backends[2].active.Add(1)
+	assert.Equal(t, backends[1], s.Pick(backends, nil))
+}
+
+func TestConsistentHash(t *testing.T) {
+	_, _, front := startBalancer(t, 3, ConsistentHash("X-User"))
+
+	// Test: Same key, same backend
+	_, first := get(t, "GET", front+"/", "X-User", "alice")
+	for i := 0; i < 5; i++ {
+		_, body := get(t, "GET", front+"/", "X-User", "alice")
+		assert.Equal(t, first, body)
+	}
+
+	// Test: Removing a backend only moves its own keys
+	backends := []*Backend{
+		newBackend(&url.URL{Host: "a"}),
+		newBackend(&url.URL{Host: "b"}),
+		newBackend(&url.URL{Host: "c"}),
+	}
+	s := ConsistentHash("X-User")
+	before := map[string]*Backend{}
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("user-%d", i)
+		before[key] = s.Pick(backends, userRequest(key))
+	}
+	for key, b := range before {
+		after := s.Pick(backends[:2], userRequest(key))
+		if b != backends[2] {
+			assert.Equal(t, b, after, key)
+		}
+	}
+
+	// Test: The ring is built once, not per set of available backends
+	ring := s.(*consistentHash).ring
+	s.Pick(backends[1:], userRequest("alice"))
+	s.Pick(backends[:1], userRequest("alice"))
+	assert.Same(t, &ring[0], &s.(*consistentHash).ring[0])
+
+	// Test: Falls back to the client IP
+	req := &request.Request{Headers: headers.NewHeaders(), RemoteAddr: "10.0.0.7:5555"}
+	assert.Equal(t, s.Pick(backends, req), s.Pick(backends, &request.Request{Headers: headers.NewHeaders(), RemoteAddr: "10.0.0.7:6666"}))
+}
+
+func userRequest(user string) *request.Request {
+	h := headers.NewHeaders()
+	h.Set("X-User", user)
+	return &request.Request{Headers: h}
+}
+
+func TestPassiveEjectionAndRetries(t *testing.T) {
+	b, backends, front := startBalancer(t, 3, RoundRobin())
+	b.MaxFails = 1
+	b.EjectFor = time.Minute
+	b.Retries = 2
+	backends[1].srv.Close()
+
+	// Test: Idempotent requests are retried on another backend
+	var names []string
+	for i := 0; i < 4; i++ {
+		status, body := get(t, "GET", front+"/")
+		assert.Equal(t, 200, status)
+		names = append(names, body)
+	}
+	assert.NotContains(t, names, "b1")
+
+	// Test: The failing backend got ejected
+	assert.False(t, b.Backends[1].Available(time.Now()))
+	assert.True(t, b.Backends[1].Available(time.Now().Add(2*time.Minute)))
+
+	// Test: Non-idempotent requests are not retried
+	b.Retries = 0
+	b.MaxFails = 0
+	b.Backends[1].mu.Lock()
+	b.Backends[1].ejectedUntil = time.Time{}
+	b.Backends[1].mu.Unlock()
+	statuses := map[int]int{}
+	for i := 0; i < 3; i++ {
+		status, _ := get(t, "POST", front+"/")
+		statuses[status]++
+	}
+	assert.Equal(t, map[int]int{200: 2, 502: 1}, statuses)
+}
+
+func TestActiveHealthChecks(t *testing.T) {
+	b, backends, front := startBalancer(t, 2, RoundRobin())
+	b.HealthCheck = HealthCheck{Path: "/health", Interval: 10 * time.Millisecond}
+	stop := b.StartHealthChecks()
+	defer stop()
+
+	// Test: Failing health checks take a backend out of rotation
+	backends[0].healthy.Store(false)
+	assert.Eventually(t, func() bool { return !b.Backends[0].Available(time.Now()) }, 2*time.Second, 10*time.Millisecond)
+	for i := 0; i < 3; i++ {
+		_, body := get(t, "GET", front+"/")
+		assert.Equal(t, "b1", body)
+	}
+
+	// Test: Whole pool down is a 503
+	backends[1].healthy.Store(false)
+	assert.Eventually(t, func() bool { return !b.Backends[1].Available(time.Now()) }, 2*time.Second, 10*time.Millisecond)
+	status, _ := get(t, "GET", front+"/")
+	assert.Equal(t, 503, status)
+
+	// Test: Recovered backends come back
+	backends[0].healthy.Store(true)
+	assert.Eventually(t, func() bool { return b.Backends[0].Available(time.Now()) }, 2*time.Second, 10*time.Millisecond)
+	_, body := get(t, "GET", front+"/")
+	assert.Equal(t, "b0", body)
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"net"
+	"strings"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/headers"
+	"github.com/DanilShapilov/httpfromtcp/internal/request"
+)
+
+// hopByHopHeaders apply to a single connection and must not be forwarded
+// (RFC 9110 section 7.6.1).
+var hopByHopHeaders = []string{
+	"connection",
+	"proxy-connection",
+	"keep-alive",
+	"proxy-authenticate",
+	"proxy-authorization",
+	"te",
+	"trailer",
+	"transfer-encoding",
+	"upgrade",
+}
+
+// removeHopByHop deletes hop-by-hop headers from h, including the ones the
+// sender listed in its Connection header.
+func removeHopByHop(h headers.Headers) {
+	if connection, exists := h.Get("connection"); exists {
+		for _, name := range strings.Split(connection, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Remove(name)
+			}
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		h.Remove(name)
+	}
+}
+
+// addForwarded records the client and the original host and scheme, both in
+// the de facto X-Forwarded-* headers and in the standard Forwarded header
+// (RFC 7239), appending to what earlier proxies already wrote.
+func addForwarded(h headers.Headers, req *request.Request) {
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+	host, _ := req.Headers.Get("host")
+	clientIP, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		clientIP = req.RemoteAddr
+	}
+
+	if clientIP != "" {
+		h.Set("X-Forwarded-For", clientIP)
+	}
+	if _, exists := h.Get("X-Forwarded-Host"); !exists && host != "" {
+		h.Set("X-Forwarded-Host", host)
+	}
+	if _, exists := h.Get("X-Forwarded-Proto"); !exists {
+		h.Set("X-Forwarded-Proto", proto)
+	}
+
+	var elems []string
+	if clientIP != "" {
+		elems = append(elems, "for="+forwardedNode(clientIP))
+	}
+	if host != "" {
+		elems = append(elems, "host="+quoteIfNeeded(host))
+	}
+	elems = append(elems, "proto="+proto)
+	h.Set("Forwarded", strings.Join(elems, ";"))
+}
+
+// forwardedNode formats an IP as a Forwarded node, which needs IPv6
+// addresses bracketed and quoted.
+func forwardedNode(ip string) string {
+	if strings.Contains(ip, ":") {
+		return `"[` + ip + `]"`
+	}
+	return ip
+}
+
+// quoteIfNeeded quotes values that aren't a plain token, like host:port.
+func quoteIfNeeded(value string) string {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0) {
+			return `"` + value + `"`
+		}
+	}
+	return value
+}
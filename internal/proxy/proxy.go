@@ -0,0 +1,265 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/client"
+	"github.com/DanilShapilov/httpfromtcp/internal/headers"
+	"github.com/DanilShapilov/httpfromtcp/internal/request"
+	"github.com/DanilShapilov/httpfromtcp/internal/response"
+	"github.com/DanilShapilov/httpfromtcp/internal/tracing"
+)
+
+const copyBufferSize = 32 * 1024
+
+// ReverseProxy forwards requests to a single upstream and streams the
+// upstream's response back, status, headers, body and trailers included.
+type ReverseProxy struct {
+	// Target is the upstream base URL. Its path is prepended to every
+	// forwarded request target.
+	Target *url.URL
+	// StripPrefix is removed from the request target before forwarding, if
+	// it ends on a path segment boundary: "/api" strips "/api/x" and
+	// "/api?q=1" but not "/apix".
+	StripPrefix string
+	// Client makes the upstream requests. Defaults to client.DefaultClient.
+	Client *client.Client
+	// ErrorLog receives upstream errors. Defaults to the standard logger.
+	ErrorLog *log.Logger
+}
+
+// New returns a ReverseProxy for the upstream base URL target.
+func New(target string) (*ReverseProxy, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.New("proxy: target must be an http or https URL")
+	}
+	return &ReverseProxy{Target: u}, nil
+}
+
+func clientOrDefault(c *client.Client) *client.Client {
+	if c != nil {
+		return c
+	}
+	return client.DefaultClient
+}
+
+func logf(l *log.Logger, format string, args ...any) {
+	if l != nil {
+		l.Printf(format, args...)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+func (p *ReverseProxy) logf(format string, args ...any) {
+	logf(p.ErrorLog, format, args...)
+}
+
+// Handle proxies req. It has the server.Handler signature.
+func (p *ReverseProxy) Handle(w *response.Writer, req *request.Request) {
+	outReq, err := outgoingRequest(req, p.Target, p.StripPrefix)
+	if err != nil {
+		p.logf("proxy: building upstream request: %v", err)
+		writeError(w, response.StatusCodeBadGateway)
+		return
+	}
+
+	res, err := clientOrDefault(p.Client).Do(req.Context(), outReq)
+	if err != nil {
+		if req.Context().Err() != nil {
+			// client is gone, nobody to answer to
+			return
+		}
+		p.logf("proxy: %s %s: %v", outReq.Method, outReq.URL, err)
+		writeError(w, response.StatusCodeBadGateway)
+		return
+	}
+	defer res.Body.Close()
+
+	if err := copyResponse(w, req, res); err != nil && req.Context().Err() == nil {
+		p.logf("proxy: copying response from %s: %v", outReq.URL, err)
+	}
+}
+
+// outgoingRequest builds the upstream request: same method, body and end to
+// end headers, retargeted at base, with forwarding headers added.
+func outgoingRequest(req *request.Request, base *url.URL, stripPrefix string) (*client.Request, error) {
+	target := stripPathPrefix(req.RequestLine.RequestTarget, stripPrefix)
+	rawPath, rawQuery, _ := strings.Cut(target, "?")
+	path, err := url.PathUnescape(rawPath)
+	if err != nil {
+		return nil, err
+	}
+	u := *base
+	// RawPath keeps the client's escaping, like %2F, that Path alone loses
+	u.Path = singleJoiningSlash(base.Path, path)
+	u.RawPath = singleJoiningSlash(base.EscapedPath(), rawPath)
+	u.RawQuery = rawQuery
+
+	outReq, err := client.NewRequest(req.RequestLine.Method, u.String(), req.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	h := outReq.Headers
+	for key, value := range req.Headers {
+		h.Set(key, value)
+	}
+	removeHopByHop(h)
+	h.Remove("host")
+	h.Remove("content-length")
+	addForwarded(h, req)
+	tracing.Inject(req.Context(), h.Set)
+	return outReq, nil
+}
+
+// copyResponse streams res to the client. Responses of known length keep
+// their Content-Length, everything else is sent chunked so the upstream's
+// trailers, and digests of the body unless it sent its own, can follow it.
+func copyResponse(w *response.Writer, req *request.Request, res *client.Response) error {
+	h := headers.NewHeaders()
+	for key, value := range res.Headers {
+		h.Set(key, value)
+	}
+	// the upstream's Trailer header is hop-by-hop, but the trailers it
+	// announces are forwarded
+	trailerNames := forwardedTrailers(h)
+	removeHopByHop(h)
+	h.Override("Connection", "close")
+
+	noBody := req.RequestLine.Method == "HEAD" || res.StatusCode == 204 || res.StatusCode == 304 ||
+		res.StatusCode >= 100 && res.StatusCode < 200
+	chunked := !noBody && (res.ContentLength < 0 || len(trailerNames) > 0)
+	if chunked {
+		h.Remove("Content-Length")
+		h.Override("Transfer-Encoding", "chunked")
+		if len(trailerNames) > 0 {
+			h.Override("Trailer", strings.Join(trailerNames, ", "))
+		}
+		if !hasDigest(h, trailerNames) {
+			if err := w.DigestTrailers(); err != nil {
+				return err
+			}
+		}
+	} else if res.ContentLength >= 0 && !noBody {
+		h.Override("Content-Length", strconv.Itoa(res.ContentLength))
+	}
+
+	if err := w.WriteStatusLine(response.StatusCode(res.StatusCode)); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+	if noBody {
+		return nil
+	}
+	if !chunked {
+		// the client's body and the writer between them splice the body
+		// from the backend to the client when both are plain TCP
+		_, err := io.Copy(w, res.Body)
+		return err
+	}
+
+	buf := make([]byte, copyBufferSize)
+	for {
+		n, err := res.Body.Read(buf)
+		if n > 0 {
+			// bodies of unknown length may be streamed, like event
+			// streams, so every chunk is passed on as it arrives
+			if _, werr := w.WriteChunkedBody(buf[:n]); werr != nil {
+				return werr
+			}
+			if werr := w.Flush(); werr != nil {
+				return werr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := w.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+	// res.Trailers is only filled in once the body has been read to EOF, and
+	// only the announced ones get through
+	trailers := headers.NewHeaders()
+	for _, name := range trailerNames {
+		if value, exists := res.Trailers.Get(name); exists {
+			trailers.Set(name, value)
+		}
+	}
+	return w.WriteTrailers(trailers)
+}
+
+// forwardedTrailers returns the trailer names announced in h that may be
+// sent on.
+func forwardedTrailers(h headers.Headers) []string {
+	list, _ := h.Get("trailer")
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" && response.AllowedTrailer(name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// hasDigest reports whether the upstream already sent a digest of the
+// body, as a header or an announced trailer, which is then passed on as is.
+func hasDigest(h headers.Headers, trailerNames []string) bool {
+	for _, name := range []string{"Content-Digest", "Repr-Digest"} {
+		if _, ok := h.Get(name); ok {
+			return true
+		}
+		if slices.ContainsFunc(trailerNames, func(t string) bool { return strings.EqualFold(t, name) }) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeError(w *response.Writer, statusCode response.StatusCode) {
+	body := []byte(response.ReasonPhrase(statusCode))
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+// stripPathPrefix removes prefix from target when what follows starts a new
+// path segment or the query.
+func stripPathPrefix(target, prefix string) string {
+	rest, ok := strings.CutPrefix(target, prefix)
+	if !ok || prefix == "" {
+		return target
+	}
+	if rest == "" || rest[0] == '/' || rest[0] == '?' || strings.HasSuffix(prefix, "/") {
+		return rest
+	}
+	return target
+}
+
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
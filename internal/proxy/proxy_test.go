@@ -0,0 +1,209 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/headers"
+	"github.com/DanilShapilov/httpfromtcp/internal/request"
+	"github.com/DanilShapilov/httpfromtcp/internal/response"
+	"github.com/DanilShapilov/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// largeBody is big enough that most of it is spliced rather than parsed.
+var largeBody = strings.Repeat("0123456789", 100000)
+
+// upstreamHandler echoes what it received on /echo, streams a chunked body
+// with trailers on /stream and one with its own Content-Digest on /digested,
+// sends largeBody on /large and answers 404 otherwise.
+func upstreamHandler(w *response.Writer, req *request.Request) {
+	switch {
+	case strings.HasPrefix(req.RequestLine.RequestTarget, "/base/echo"):
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s %s\n", req.RequestLine.Method, req.RequestLine.RequestTarget)
+		for _, name := range []string{"host", "x-forwarded-for", "x-forwarded-host", "x-forwarded-proto", "forwarded", "x-custom", "keep-alive", "x-drop-me"} {
+			value, _ := req.Headers.Get(name)
+			fmt.Fprintf(&b, "%s=%s\n", name, value)
+		}
+		b.Write(req.Body)
+		body := []byte(b.String())
+		h := response.GetDefaultHeaders(len(body))
+		h.Set("X-Upstream", "yes")
+		h.Set("Keep-Alive", "timeout=5")
+		h.Set("Set-Cookie", "a=1; Expires=Wed, 01 May 2024 12:00:00 GMT")
+		h.Set("Set-Cookie", "b=2")
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		w.WriteHeaders(h)
+		w.WriteBody(body)
+	case req.RequestLine.RequestTarget == "/base/stream":
+		h := response.GetDefaultHeaders(0)
+		h.Remove("Content-Length")
+		h.Override("Transfer-Encoding", "chunked")
+		h.Override("Trailer", "X-Checksum")
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte("hello "))
+		w.WriteChunkedBody([]byte("world"))
+		w.WriteChunkedBodyDone()
+		trailers := headers.NewHeaders()
+		trailers.Set("X-Checksum", "abc123")
+		w.WriteTrailers(trailers)
+	case req.RequestLine.RequestTarget == "/base/digested":
+		h := response.GetDefaultHeaders(0)
+		h.Remove("Content-Length")
+		h.Override("Transfer-Encoding", "chunked")
+		h.Override("Trailer", "Content-Digest")
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte("hello"))
+		w.WriteChunkedBodyDone()
+		trailers := headers.NewHeaders()
+		trailers.Set("Content-Digest", "sha-256=:upstream:")
+		w.WriteTrailers(trailers)
+	case req.RequestLine.RequestTarget == "/base/large":
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		w.WriteHeaders(response.GetDefaultHeaders(len(largeBody)))
+		w.WriteBody([]byte(largeBody))
+	default:
+		body := []byte("nope")
+		w.WriteStatusLine(response.StatusCodeNotFound)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}
+}
+
+func startProxy(t *testing.T) string {
+	t.Helper()
+	upstream, err := server.ServeConfig(server.Config{Addr: "127.0.0.1:0"}, upstreamHandler)
+	require.NoError(t, err)
+	t.Cleanup(func() { upstream.Close() })
+
+	p, err := New("http://" + upstream.Addr().String() + "/base")
+	require.NoError(t, err)
+	p.StripPrefix = "/api"
+	p.ErrorLog = log.New(io.Discard, "", 0)
+	front, err := server.ServeConfig(server.Config{Addr: "127.0.0.1:0"}, p.Handle)
+	require.NoError(t, err)
+	t.Cleanup(func() { front.Close() })
+	return "http://" + front.Addr().String()
+}
+
+func TestReverseProxy(t *testing.T) {
+	base := startProxy(t)
+
+	// Test: Method, body, end to end headers and query are forwarded
+	req, err := http.NewRequest("POST", base+"/api/echo?x=1", strings.NewReader("payload"))
+	require.NoError(t, err)
+	req.Header.Set("X-Custom", "kept")
+	req.Header.Set("X-Drop-Me", "dropped")
+	req.Header.Set("Connection", "X-Drop-Me")
+	req.Header.Set("X-Forwarded-For", "10.0.0.1")
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "yes", res.Header.Get("X-Upstream"))
+	assert.Empty(t, res.Header.Get("Keep-Alive"))
+	assert.Equal(t, []string{"a=1; Expires=Wed, 01 May 2024 12:00:00 GMT", "b=2"}, res.Header.Values("Set-Cookie"))
+	echo := string(body)
+	assert.Contains(t, echo, "POST /base/echo?x=1\n")
+	assert.Contains(t, echo, "x-custom=kept\n")
+	assert.Contains(t, echo, "x-drop-me=\n")
+	assert.Contains(t, echo, "x-forwarded-for=10.0.0.1, 127.0.0.1\n")
+	assert.Contains(t, echo, "x-forwarded-host="+strings.TrimPrefix(base, "http://")+"\n")
+	assert.Contains(t, echo, "x-forwarded-proto=http\n")
+	assert.Contains(t, echo, "forwarded=for=127.0.0.1;host=\""+strings.TrimPrefix(base, "http://")+"\";proto=http\n")
+	assert.True(t, strings.HasSuffix(echo, "\npayload"))
+
+	// Test: Escaped paths are forwarded with their escaping
+	for _, path := range []string{"/echo/a%20b", "/echo/a%2Fb", "/echo/caf%C3%A9"} {
+		res, err = http.Get(base + "/api" + path)
+		require.NoError(t, err)
+		body, _ = io.ReadAll(res.Body)
+		res.Body.Close()
+		assert.Contains(t, string(body), "GET /base"+path+"\n")
+	}
+
+	// Test: The prefix is only stripped on a path segment boundary
+	res, err = http.Get(base + "/apiecho")
+	require.NoError(t, err)
+	body, _ = io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, 404, res.StatusCode, "forwarded as /base/echo")
+	assert.Equal(t, "nope", string(body))
+	assert.Equal(t, "?q=1", stripPathPrefix("/api?q=1", "/api"))
+	assert.Equal(t, "", stripPathPrefix("/api", "/api"))
+	assert.Equal(t, "x", stripPathPrefix("/api/x", "/api/"))
+
+	// Test: Upstream status is preserved
+	res, err = http.Get(base + "/api/missing")
+	require.NoError(t, err)
+	body, _ = io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, 404, res.StatusCode)
+	assert.Equal(t, "nope", string(body))
+
+	// Test: Chunked responses are streamed with trailers
+	res, err = http.Get(base + "/api/stream")
+	require.NoError(t, err)
+	body, _ = io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, []string{"chunked"}, res.TransferEncoding)
+	assert.Equal(t, "hello world", string(body))
+	assert.Equal(t, "abc123", res.Trailer.Get("X-Checksum"))
+	sum := sha256.Sum256([]byte("hello world"))
+	want := "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+	assert.Equal(t, want, res.Trailer.Get("Content-Digest"))
+	assert.Equal(t, want, res.Trailer.Get("Repr-Digest"))
+
+	// Test: Digests sent by the upstream are passed on as is
+	res, err = http.Get(base + "/api/digested")
+	require.NoError(t, err)
+	body, _ = io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, "hello", string(body))
+	assert.Equal(t, "sha-256=:upstream:", res.Trailer.Get("Content-Digest"))
+	assert.Empty(t, res.Trailer.Get("Repr-Digest"))
+
+	// Test: Large bodies are copied from connection to connection
+	res, err = http.Get(base + "/api/large")
+	require.NoError(t, err)
+	body, _ = io.ReadAll(res.Body)
+	res.Body.Close()
+	assert.Equal(t, int64(len(largeBody)), res.ContentLength)
+	assert.Equal(t, largeBody, string(body))
+
+	// Test: HEAD responses have no body
+	res, err = http.Head(base + "/api/echo")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, 200, res.StatusCode)
+}
+
+func TestReverseProxyBadGateway(t *testing.T) {
+	// Test: Unreachable upstream is a 502
+	p, err := New("http://127.0.0.1:1")
+	require.NoError(t, err)
+	p.ErrorLog = log.New(io.Discard, "", 0)
+	front, err := server.ServeConfig(server.Config{Addr: "127.0.0.1:0"}, p.Handle)
+	require.NoError(t, err)
+	defer front.Close()
+	res, err := http.Get("http://" + front.Addr().String() + "/")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, 502, res.StatusCode)
+
+	// Test: Only http and https targets
+	_, err = New("ftp://example.com")
+	require.Error(t, err)
+}
@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"hash/crc32"
+	"net"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/request"
+)
+
+// Strategy picks the backend for a request among the available ones. It is
+// never called with an empty slice, and the slice keeps the pool order.
+type Strategy interface {
+	Pick(backends []*Backend, req *request.Request) *Backend
+}
+
+type roundRobin struct {
+	next atomic.Uint64
+}
+
+// RoundRobin cycles through the available backends.
+func RoundRobin() Strategy {
+	return &roundRobin{}
+}
+
+func (rr *roundRobin) Pick(backends []*Backend, _ *request.Request) *Backend {
+	n := rr.next.Add(1) - 1
+	return backends[n%uint64(len(backends))]
+}
+
+type leastConnections struct{}
+
+// LeastConnections picks the backend with the fewest requests in flight,
+// the first one in pool order on ties.
+func LeastConnections() Strategy {
+	return leastConnections{}
+}
+
+func (leastConnections) Pick(backends []*Backend, _ *request.Request) *Backend {
+	best := backends[0]
+	for _, b := range backends[1:] {
+		if b.ActiveRequests() < best.ActiveRequests() {
+			best = b
+		}
+	}
+	return best
+}
+
+// virtualNodes is the number of points each backend gets on the hash ring,
+// enough to spread keys evenly over a handful of backends.
+const virtualNodes = 100
+
+type consistentHash struct {
+	header string
+
+	mu     sync.Mutex
+	onRing map[*Backend]bool
+	ring   []ringPoint // replaced, never modified, when backends are added
+}
+
+type ringPoint struct {
+	hash    uint32
+	backend *Backend
+}
+
+// ConsistentHash sends requests with the same key to the same backend, and
+// moves only the keys of a backend that goes away. The key is the value of
+// header, or the client IP when header is empty or missing from the request.
+func ConsistentHash(header string) Strategy {
+	return &consistentHash{header: header}
+}
+
+func (ch *consistentHash) Pick(backends []*Backend, req *request.Request) *Backend {
+	ring := ch.ringFor(backends)
+	h := crc32.ChecksumIEEE([]byte(ch.key(req)))
+	i := sort.Search(len(ring), func(i int) bool { return ring[i].hash >= h })
+	// points of backends left out, being down, ejected or already tried,
+	// are passed clockwise, which only moves the keys of those backends
+	for n := range len(ring) {
+		if p := ring[(i+n)%len(ring)]; slices.Contains(backends, p.backend) {
+			return p.backend
+		}
+	}
+	return backends[0]
+}
+
+func (ch *consistentHash) key(req *request.Request) string {
+	if ch.header != "" {
+		if value, exists := req.Headers.Get(ch.header); exists {
+			return value
+		}
+	}
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return ip
+}
+
+// ringFor returns the ring of every backend seen so far, adding the ones in
+// backends it doesn't have yet. Once the whole pool has been seen it stays
+// the same whichever backends are available.
+func (ch *consistentHash) ringFor(backends []*Backend) []ringPoint {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	ring := ch.ring
+	for _, b := range backends {
+		if ch.onRing[b] {
+			continue
+		}
+		if ch.onRing == nil {
+			ch.onRing = make(map[*Backend]bool)
+		}
+		ch.onRing[b] = true
+		// clipped, so the ring handed out before isn't written to
+		ring = slices.Clip(ring)
+		for i := 0; i < virtualNodes; i++ {
+			h := crc32.ChecksumIEEE([]byte(b.URL.String() + "#" + strconv.Itoa(i)))
+			ring = append(ring, ringPoint{hash: h, backend: b})
+		}
+	}
+	if len(ring) != len(ch.ring) {
+		sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+		ch.ring = ring
+	}
+	return ch.ring
+}
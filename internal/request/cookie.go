@@ -0,0 +1,23 @@
+package request
+
+import "github.com/DanilShapilov/httpfromtcp/internal/cookie"
+
+// Cookies returns the cookies the client sent.
+func (r *Request) Cookies() []*cookie.Cookie {
+	value, exists := r.Headers.Get("cookie")
+	if !exists {
+		return nil
+	}
+	return cookie.Parse(value)
+}
+
+// Cookie returns the first cookie with the given name, or
+// cookie.ErrNoCookie.
+func (r *Request) Cookie(name string) (*cookie.Cookie, error) {
+	for _, c := range r.Cookies() {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return nil, cookie.ErrNoCookie
+}
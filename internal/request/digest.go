@@ -0,0 +1,48 @@
+package request
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/digest"
+)
+
+var (
+	ErrDigestMismatch    = errors.New("body does not match its digest")
+	ErrDigestUnsupported = errors.New("no supported digest algorithm")
+)
+
+// VerifyDigest checks the body against the Content-Digest and Repr-Digest
+// fields. Bodies are not content coded here, so both are checked against the
+// body as received, and every supported algorithm present must match. It
+// returns nil when the request carries no digest, and ErrDigestUnsupported
+// when a field only lists algorithms we can't compute.
+func (r *Request) VerifyDigest() error {
+	for _, field := range []string{"content-digest", "repr-digest"} {
+		value, exists := r.Headers.Get(field)
+		if !exists {
+			continue
+		}
+		digests, err := digest.Parse(value)
+		if err != nil {
+			return err
+		}
+		checked := 0
+		for alg, want := range digests {
+			h := digest.New(alg)
+			if h == nil {
+				continue
+			}
+			h.Write(r.Body)
+			if subtle.ConstantTimeCompare(h.Sum(nil), want) != 1 {
+				return fmt.Errorf("%w: %s %s", ErrDigestMismatch, field, alg)
+			}
+			checked++
+		}
+		if checked == 0 {
+			return fmt.Errorf("%w in %s", ErrDigestUnsupported, field)
+		}
+	}
+	return nil
+}
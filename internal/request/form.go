@@ -0,0 +1,271 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// DefaultMaxMemory is how much of a multipart form's values and files
+// MultipartForm keeps in memory when FormLimits.MaxMemory is zero.
+const DefaultMaxMemory = 10 << 20
+
+// valueAllowance is how far values may go past MaxMemory, as in net/http:
+// unlike files they can't be spooled.
+const valueAllowance = 10 << 20
+
+var (
+	ErrNotForm      = errors.New("request body is not a form")
+	ErrPartTooLarge = errors.New("multipart form part too large")
+	ErrFormTooLarge = errors.New("multipart form too large")
+)
+
+// FormLimits bounds what MultipartForm keeps of a form. Zero values mean no
+// limit, except for MaxMemory.
+type FormLimits struct {
+	// MaxMemory caps the bytes of values and files kept in memory, files
+	// past it are spooled to temporary files. Values count against it too,
+	// as in net/http, and fail with ErrFormTooLarge once they are 10 MiB
+	// past it. Zero means DefaultMaxMemory.
+	MaxMemory int64
+	// MaxPartBytes caps every part, file or not.
+	MaxPartBytes int64
+	// MaxFormBytes caps all parts together.
+	MaxFormBytes int64
+}
+
+// Query returns the parameters of the query string. Malformed pairs are
+// left out.
+func (r *Request) Query() url.Values {
+	_, query, _ := strings.Cut(r.RequestLine.RequestTarget, "?")
+	values, _ := url.ParseQuery(query)
+	return values
+}
+
+// PostForm parses an application/x-www-form-urlencoded body. It fails with
+// ErrNotForm for other content types.
+func (r *Request) PostForm() (url.Values, error) {
+	mediaType, _, err := r.mediaType()
+	if err != nil || mediaType != "application/x-www-form-urlencoded" {
+		return nil, ErrNotForm
+	}
+	return url.ParseQuery(string(r.Body))
+}
+
+// FormValue returns the first value of key in a url-encoded body, or in the
+// query string when the body has none. Multipart forms are read with
+// MultipartForm instead.
+func (r *Request) FormValue(key string) string {
+	if form, err := r.PostForm(); err == nil {
+		if values := form[key]; len(values) > 0 {
+			return values[0]
+		}
+	}
+	return r.Query().Get(key)
+}
+
+// MultipartReader returns a reader over the parts of a multipart/form-data
+// body, for handlers that process one part at a time. The parts are read
+// from Body: the server reads the whole body before the handler runs, so
+// the parts are not streamed from the connection.
+func (r *Request) MultipartReader() (*multipart.Reader, error) {
+	mediaType, params, err := r.mediaType()
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		return nil, ErrNotForm
+	}
+	return multipart.NewReader(bytes.NewReader(r.Body), params["boundary"]), nil
+}
+
+func (r *Request) mediaType() (string, map[string]string, error) {
+	contentType, exists := r.Headers.Get("content-type")
+	if !exists {
+		return "", nil, ErrNotForm
+	}
+	return mime.ParseMediaType(contentType)
+}
+
+// Form is a parsed multipart form.
+type Form struct {
+	Value url.Values
+	File  map[string][]*FileHeader
+}
+
+// FileHeader describes a file part of a multipart form.
+type FileHeader struct {
+	Filename string
+	// Header holds the part's headers, like Content-Type, each line of a
+	// repeated one apart.
+	Header textproto.MIMEHeader
+	Size   int64
+
+	content []byte
+	tmpfile string
+}
+
+// File is the content of a FileHeader.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+}
+
+// Open returns the content of the file, from memory or from its temporary
+// file.
+func (fh *FileHeader) Open() (File, error) {
+	if fh.tmpfile != "" {
+		return os.Open(fh.tmpfile)
+	}
+	return nopCloser{bytes.NewReader(fh.content)}, nil
+}
+
+type nopCloser struct{ *bytes.Reader }
+
+func (nopCloser) Close() error { return nil }
+
+// RemoveAll deletes the temporary files of the form. Handlers defer it
+// right after MultipartForm succeeded.
+func (f *Form) RemoveAll() error {
+	var errs []error
+	for _, files := range f.File {
+		for _, fh := range files {
+			if fh.tmpfile == "" {
+				continue
+			}
+			if err := os.Remove(fh.tmpfile); err != nil && !errors.Is(err, os.ErrNotExist) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// MultipartForm decodes a multipart/form-data body. Values are kept in
+// memory, files too until limits.MaxMemory is used up; the rest go to
+// temporary files. Body itself stays in memory all along, see
+// MultipartReader. It fails with ErrNotForm for other content types, and
+// with ErrPartTooLarge or ErrFormTooLarge once the form outgrows limits;
+// nothing is left on disk then.
+func (r *Request) MultipartForm(limits FormLimits) (*Form, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	form := &Form{Value: make(url.Values), File: make(map[string][]*FileHeader)}
+	if err := readForm(mr, form, limits); err != nil {
+		form.RemoveAll()
+		return nil, err
+	}
+	return form, nil
+}
+
+func readForm(mr *multipart.Reader, form *Form, limits FormLimits) error {
+	memory := limits.MaxMemory
+	if memory <= 0 {
+		memory = DefaultMaxMemory
+	}
+	valueBytes := memory + valueAllowance
+	formCap := &capReader{n: orUnlimited(limits.MaxFormBytes), err: ErrFormTooLarge}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrBody, err)
+		}
+		formCap.r = part
+		r := &capReader{r: formCap, n: orUnlimited(limits.MaxPartBytes), err: ErrPartTooLarge}
+
+		name := part.FormName()
+		if name == "" {
+			if _, err := io.Copy(io.Discard, r); err != nil {
+				return err
+			}
+			continue
+		}
+		if part.FileName() == "" {
+			value, err := io.ReadAll(&capReader{r: r, n: valueBytes, err: ErrFormTooLarge})
+			if err != nil {
+				return err
+			}
+			valueBytes -= int64(len(value))
+			memory -= int64(len(value))
+			form.Value.Add(name, string(value))
+			continue
+		}
+
+		fh := &FileHeader{Filename: part.FileName(), Header: part.Header}
+		// keep the file in memory if it fits, spool it otherwise
+		content, err := io.ReadAll(io.LimitReader(r, max(memory, 0)+1))
+		if err != nil {
+			return err
+		}
+		if int64(len(content)) <= memory {
+			fh.content = content
+			fh.Size = int64(len(content))
+			memory -= fh.Size
+		} else if err := spool(fh, content, r); err != nil {
+			// let RemoveAll find the file
+			form.File[name] = append(form.File[name], fh)
+			return err
+		}
+		form.File[name] = append(form.File[name], fh)
+	}
+}
+
+// spool writes head and the rest of r to a temporary file for fh.
+func spool(fh *FileHeader, head []byte, r io.Reader) error {
+	f, err := os.CreateTemp("", "multipart-")
+	if err != nil {
+		return err
+	}
+	fh.tmpfile = f.Name()
+	n, err := f.Write(head)
+	if err == nil {
+		var rest int64
+		rest, err = io.Copy(f, r)
+		fh.Size = int64(n) + rest
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func orUnlimited(limit int64) int64 {
+	if limit <= 0 {
+		return math.MaxInt64 - 1
+	}
+	return limit
+}
+
+// capReader fails with err once more than n bytes were read through it.
+type capReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (c *capReader) Read(p []byte) (int, error) {
+	if c.n < 0 {
+		return 0, c.err
+	}
+	if int64(len(p)) > c.n+1 {
+		p = p[:c.n+1]
+	}
+	n, err := c.r.Read(p)
+	c.n -= int64(n)
+	if c.n < 0 {
+		return n, c.err
+	}
+	return n, err
+}
@@ -0,0 +1,57 @@
+package request
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+)
+
+// Identity is what a verified client certificate says about the client.
+type Identity struct {
+	Subject        pkix.Name
+	DNSNames       []string
+	EmailAddresses []string
+	URIs           []*url.URL
+	IPAddresses    []net.IP
+
+	// Chain is the verified chain, leaf first, ending at a trusted CA
+	Chain []*x509.Certificate
+}
+
+// IdentityFromTLS returns the identity of a client that presented a
+// certificate the server verified, or nil if there is none.
+func IdentityFromTLS(state *tls.ConnectionState) *Identity {
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return nil
+	}
+	chain := state.VerifiedChains[0]
+	leaf := chain[0]
+	return &Identity{
+		Subject:        leaf.Subject,
+		DNSNames:       leaf.DNSNames,
+		EmailAddresses: leaf.EmailAddresses,
+		URIs:           leaf.URIs,
+		IPAddresses:    leaf.IPAddresses,
+		Chain:          chain,
+	}
+}
+
+// Names returns every name the identity goes by: the subject common name
+// followed by the DNS, email, URI and IP subject alternative names.
+func (id *Identity) Names() []string {
+	var names []string
+	if id.Subject.CommonName != "" {
+		names = append(names, id.Subject.CommonName)
+	}
+	names = append(names, id.DNSNames...)
+	names = append(names, id.EmailAddresses...)
+	for _, u := range id.URIs {
+		names = append(names, u.String())
+	}
+	for _, ip := range id.IPAddresses {
+		names = append(names, ip.String())
+	}
+	return names
+}
@@ -2,17 +2,34 @@ package request
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/DanilShapilov/httpfromtcp/internal/headers"
 )
 
 const crlf = "\r\n"
-const bufferSize = 8
+
+// bufferSize fits the request line and headers of almost every request, so
+// the read buffer rarely has to grow.
+const bufferSize = 4096
+
+// maxBodyPrealloc bounds how much of a declared Content-Length is allocated
+// up front, before the body actually arrives.
+const maxBodyPrealloc = 64 << 10
+
+var bufPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, bufferSize)
+		return &buf
+	},
+}
 
 type ParserState int
 
@@ -23,6 +40,27 @@ const (
 	requestStateDone
 )
 
+// Limits bounds how much of a request RequestFromReaderWithLimits is willing
+// to read. Zero values mean no limit.
+type Limits struct {
+	// MaxHeaderBytes caps the request line plus headers, including CRLFs
+	MaxHeaderBytes int
+	// MaxBodyBytes caps the declared Content-Length
+	MaxBodyBytes int
+}
+
+var (
+	ErrHeaderTooLarge = errors.New("request headers too large")
+	ErrBodyTooLarge   = errors.New("request body too large")
+
+	// parse errors wrap one of these, so callers can tell what went wrong
+	// with errors.Is
+	ErrRequestLine = errors.New("malformed request line")
+	ErrHeaders     = errors.New("malformed headers")
+	ErrBody        = errors.New("malformed body")
+	ErrIncomplete  = errors.New("incomplete request")
+)
+
 type RequestLine struct {
 	HttpVersion   string
 	RequestTarget string
@@ -34,8 +72,51 @@ type Request struct {
 	Headers     headers.Headers
 	Body        []byte
 
+	// RemoteAddr is the client's network address, set by the server.
+	RemoteAddr string
+
+	// TLS is the negotiated connection state for HTTPS requests and nil
+	// for plain HTTP. The server fills it in after parsing.
+	TLS *tls.ConnectionState
+	// ClientIdentity is set when the client authenticated with a
+	// certificate the server verified against its client CAs.
+	ClientIdentity *Identity
+
 	ParserState    ParserState
 	bodyLengthRead int
+
+	limits          Limits
+	headerBytesRead int
+	// headerScan is how far the search for the end of the headers got
+	headerScan int
+	// buffered holds bytes read past the end of the request
+	buffered []byte
+
+	// ctx is cancelled when the client goes away, the handler times out
+	// or the server shuts down. Use Context and WithContext to access it.
+	ctx context.Context
+}
+
+// Context returns the request's context. It is never nil; requests that
+// were not served by a server get context.Background.
+func (r *Request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
+
+// WithContext returns a shallow copy of r with its context changed to ctx.
+// Middleware uses it to attach per-request values (request IDs, auth
+// principal, ...) before calling the next handler.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("nil context")
+	}
+	r2 := new(Request)
+	*r2 = *r
+	r2.ctx = ctx
+	return r2
 }
 
 func (r *Request) parse(data []byte) (int, error) {
@@ -62,59 +143,101 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 		rLine, n, err := parseRequestLine(data)
 		if err != nil {
 			// something actually went wrong
-			return 0, err
+			return 0, fmt.Errorf("%w: %w", ErrRequestLine, err)
 		}
 		if n == 0 {
 			// just need more data
 			return 0, nil
 		}
-		r.RequestLine = *rLine
+		r.RequestLine = rLine
 		r.ParserState = requestStateParsingHeaders
+		r.headerBytesRead += n
+		if r.exceedsHeaderLimit(0) {
+			return 0, ErrHeaderTooLarge
+		}
 		return n, nil
 	case requestStateParsingHeaders:
-		n, done, err := r.Headers.Parse(data)
-		if err != nil {
-			return 0, err
+		// the header section is parsed once it is all in, so that every
+		// value can share a single string
+		end := r.headerSectionEnd(data)
+		if end == -1 {
+			return 0, nil
 		}
-		if done {
-			r.ParserState = requestStateParsingBody
+		if r.exceedsHeaderLimit(end + len(crlf)) {
+			return 0, ErrHeaderTooLarge
 		}
-		return n, nil
+		if err := parseHeaderSection(r.Headers, string(data[:end])); err != nil {
+			return 0, fmt.Errorf("%w: %w", ErrHeaders, err)
+		}
+		r.ParserState = requestStateParsingBody
+		r.headerBytesRead += end + len(crlf)
+		return end + len(crlf), nil
 	case requestStateParsingBody:
 		contentLenStr, exists := r.Headers.Get("content-length")
 		if !exists {
 			// assume that if no content-length header is present, there is no body
 			r.ParserState = requestStateDone
-			return len(data), nil
+			return 0, nil
 		}
 
-		contentLen, err := strconv.Atoi(contentLenStr)
+		contentLen, err := parseContentLength(contentLenStr)
 		if err != nil {
-			return 0, fmt.Errorf("malformed Content-Length: %s", err)
+			return 0, err
+		}
+		if r.limits.MaxBodyBytes > 0 && contentLen > r.limits.MaxBodyBytes {
+			return 0, ErrBodyTooLarge
 		}
 
-		r.Body = append(r.Body, data...)
-		r.bodyLengthRead += len(data)
-
-		if r.bodyLengthRead > contentLen {
-			return 0, fmt.Errorf("error: request body length > Content-Length header value")
+		// contentLen is known to be non-negative here, make would panic on
+		// a negative capacity
+		if r.Body == nil {
+			r.Body = make([]byte, 0, min(contentLen, maxBodyPrealloc))
 		}
+		// anything past Content-Length belongs to whatever follows the
+		// request on the connection
+		n := min(len(data), contentLen-r.bodyLengthRead)
+		r.Body = append(r.Body, data[:n]...)
+		r.bodyLengthRead += n
+
 		if r.bodyLengthRead == contentLen {
 			r.ParserState = requestStateDone
 		}
-		return len(data), nil
+		return n, nil
 	}
 
 	return 0, fmt.Errorf("error: unknown state")
 }
 
+// parseContentLength accepts digits only (RFC 9110 section 8.6); Atoi
+// alone would take a sign, and a negative length a slice bound.
+func parseContentLength(value string) (int, error) {
+	for i := 0; i < len(value); i++ {
+		if value[i] < '0' || value[i] > '9' {
+			return 0, fmt.Errorf("%w: malformed Content-Length: %q", ErrBody, value)
+		}
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%w: malformed Content-Length: %s", ErrBody, err)
+	}
+	return n, nil
+}
+
 func RequestFromReader(reader io.Reader) (*Request, error) {
-	buf := make([]byte, bufferSize)
+	return RequestFromReaderWithLimits(reader, Limits{})
+}
+
+// RequestFromReaderWithLimits is like RequestFromReader but fails with
+// ErrHeaderTooLarge or ErrBodyTooLarge once the request outgrows limits.
+func RequestFromReaderWithLimits(reader io.Reader, limits Limits) (*Request, error) {
+	pooled := bufPool.Get().(*[]byte)
+	defer bufPool.Put(pooled)
+	buf := *pooled
 	readToIndex := 0
 	req := &Request{
 		ParserState: requestStateInitialized,
 		Headers:     headers.NewHeaders(),
-		Body:        make([]byte, 0),
+		limits:      limits,
 	}
 
 	for req.ParserState != requestStateDone {
@@ -127,13 +250,15 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 		numBytesRead, err := reader.Read(buf[readToIndex:])
 		if err != nil {
 			if errors.Is(err, io.EOF) {
-				_, error := req.parse(buf[:readToIndex]) // in case we didn't even started
+				numBytesParsed, error := req.parse(buf[:readToIndex]) // in case we didn't even started
 				if error != nil {
 					return nil, error
 				}
 				if req.ParserState != requestStateDone {
-					return nil, fmt.Errorf("incomplete request")
+					return nil, ErrIncomplete
 				}
+				copy(buf, buf[numBytesParsed:])
+				readToIndex -= numBytesParsed
 				break
 			}
 			return nil, err
@@ -147,57 +272,149 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 
 		copy(buf, buf[numBytesParsed:])
 		readToIndex -= numBytesParsed
+
+		if req.exceedsHeaderLimit(readToIndex) {
+			return nil, ErrHeaderTooLarge
+		}
+	}
+	if req.Body == nil {
+		req.Body = []byte{}
+	}
+	// nothing may point into buf once it is back in the pool
+	if readToIndex > 0 {
+		req.buffered = append([]byte(nil), buf[:readToIndex]...)
 	}
 
 	return req, nil
 }
 
-func parseRequestLine(data []byte) (*RequestLine, int, error) {
+// Buffered returns the bytes read from the reader past the end of the
+// request, like the start of a pipelined request or of another protocol
+// after an upgrade.
+func (r *Request) Buffered() []byte {
+	return r.buffered
+}
+
+// exceedsHeaderLimit reports whether the header section, counting the
+// pending unparsed bytes, is already over the limit.
+func (r *Request) exceedsHeaderLimit(pending int) bool {
+	if r.limits.MaxHeaderBytes <= 0 || r.ParserState >= requestStateParsingBody {
+		return false
+	}
+	return r.headerBytesRead+pending > r.limits.MaxHeaderBytes
+}
+
+// headerSectionEnd returns the length of the field lines at the start of
+// data, up to and including the CRLF of the last one, or -1 until the empty
+// line that ends them has arrived.
+func (r *Request) headerSectionEnd(data []byte) int {
+	if bytes.HasPrefix(data, []byte(crlf)) {
+		return 0
+	}
+	// resume where the previous call gave up, minus a partial terminator
+	from := max(r.headerScan-3, 0)
+	idx := bytes.Index(data[from:], []byte(crlf+crlf))
+	if idx == -1 {
+		r.headerScan = len(data)
+		return -1
+	}
+	return from + idx + len(crlf)
+}
+
+// parseHeaderSection adds every CRLF terminated field line of section to h.
+func parseHeaderSection(h headers.Headers, section string) error {
+	for len(section) > 0 {
+		line, rest, _ := strings.Cut(section, crlf)
+		if err := h.ParseLine(line); err != nil {
+			return err
+		}
+		section = rest
+	}
+	return nil
+}
+
+func parseRequestLine(data []byte) (RequestLine, int, error) {
 	idx := bytes.Index(data, []byte(crlf))
 	if idx == -1 {
-		return nil, 0, nil
+		return RequestLine{}, 0, nil
 	}
-	requestLineText := string(data[:idx])
-	requestLine, err := requestLineFromString(requestLineText)
+	requestLine, err := requestLineFromBytes(data[:idx])
 	if err != nil {
-		return nil, 0, err
+		return RequestLine{}, 0, err
 	}
 	return requestLine, idx + len(crlf), nil
 }
 
-func requestLineFromString(str string) (*RequestLine, error) {
-	parts := strings.Split(str, " ")
-
-	if len(parts) != 3 {
-		return nil, fmt.Errorf("request-line should match following format: method SP request-target SP HTTP-version")
+// requestLineFromBytes parses method SP request-target SP HTTP-version.
+// Only the request target is copied; known methods and the version are
+// constants.
+func requestLineFromBytes(line []byte) (RequestLine, error) {
+	method, rest, ok := bytes.Cut(line, []byte(" "))
+	if !ok {
+		return RequestLine{}, fmt.Errorf("request-line should match following format: method SP request-target SP HTTP-version")
+	}
+	target, version, ok := bytes.Cut(rest, []byte(" "))
+	if !ok || bytes.IndexByte(version, ' ') != -1 {
+		return RequestLine{}, fmt.Errorf("request-line should match following format: method SP request-target SP HTTP-version")
 	}
 
-	method := parts[0]
+	if len(method) == 0 {
+		return RequestLine{}, fmt.Errorf("invalid method: %s", method)
+	}
 	for _, c := range method {
 		if c < 'A' || c > 'Z' {
-			return nil, fmt.Errorf("invalid method: %s", method)
+			return RequestLine{}, fmt.Errorf("invalid method: %s", method)
 		}
 	}
 
-	requestTarget := parts[1]
-
-	versionParts := strings.Split(parts[2], "/")
-	if len(versionParts) != 2 {
-		return nil, fmt.Errorf("malformed start-line: %s", str)
+	if len(target) == 0 {
+		return RequestLine{}, fmt.Errorf("empty request-target")
+	}
+	for _, c := range target {
+		if c <= ' ' || c == 0x7f {
+			return RequestLine{}, fmt.Errorf("invalid character in request-target: %q", target)
+		}
 	}
 
-	httpPart := versionParts[0]
-	if httpPart != "HTTP" {
-		return nil, fmt.Errorf("unrecognized HTTP-version: %s", httpPart)
+	httpPart, versionPart, ok := bytes.Cut(version, []byte("/"))
+	if !ok || bytes.IndexByte(versionPart, '/') != -1 {
+		return RequestLine{}, fmt.Errorf("malformed start-line: %s", line)
 	}
-	version := versionParts[1]
-	if version != "1.1" {
-		return nil, fmt.Errorf("unrecognized HTTP-version: %s", httpPart)
+	if string(httpPart) != "HTTP" {
+		return RequestLine{}, fmt.Errorf("unrecognized HTTP-version: %s", httpPart)
+	}
+	if string(versionPart) != "1.1" {
+		return RequestLine{}, fmt.Errorf("unrecognized HTTP-version: %s", httpPart)
 	}
 
-	return &RequestLine{
-		Method:        method,
-		RequestTarget: requestTarget,
-		HttpVersion:   versionParts[1],
+	return RequestLine{
+		Method:        internMethod(method),
+		RequestTarget: string(target),
+		HttpVersion:   "1.1",
 	}, nil
 }
+
+// internMethod returns the standard methods without allocating.
+func internMethod(method []byte) string {
+	switch string(method) {
+	case "GET":
+		return "GET"
+	case "HEAD":
+		return "HEAD"
+	case "POST":
+		return "POST"
+	case "PUT":
+		return "PUT"
+	case "DELETE":
+		return "DELETE"
+	case "PATCH":
+		return "PATCH"
+	case "OPTIONS":
+		return "OPTIONS"
+	case "CONNECT":
+		return "CONNECT"
+	case "TRACE":
+		return "TRACE"
+	}
+	return string(method)
+}
@@ -1,10 +1,21 @@
 package request
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
 	"io"
+	"maps"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"slices"
 	"strings"
 	"testing"
 
+	"github.com/DanilShapilov/httpfromtcp/internal/cookie"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -108,6 +119,12 @@ func TestRequestLineParse(t *testing.T) {
 	// Test: Invalid protocol in Request line
 	_, err = RequestFromReader(strings.NewReader("OPTIONS /prime/rib TCP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n"))
 	require.Error(t, err)
+
+	// Test: Empty target or control characters in it
+	_, err = RequestFromReader(strings.NewReader("GET  HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	require.Error(t, err)
+	_, err = RequestFromReader(strings.NewReader("GET /\x00 HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	require.Error(t, err)
 }
 
 func TestRequestHeadersParse(t *testing.T) {
@@ -240,4 +257,495 @@ func TestRequestBodyParse(t *testing.T) {
 	}
 	r, err = RequestFromReader(reader)
 	require.Error(t, err)
+
+	// Test: Signed, negative or list Content-Length is malformed
+	for _, value := range []string{"-5", "-0", "+5", " 5x", "5, 5", ""} {
+		_, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: x\r\nContent-Length: " + value + "\r\n\r\nhello"))
+		require.ErrorIs(t, err, ErrBody, value)
+	}
+
+	// Test: Malformed Content-Length fails before the body is allocated,
+	// even when the headers arrive alone
+	for _, value := range []string{"-5", "-9223372036854775808", "99999999999999999999"} {
+		reader = &chunkReader{
+			data:            "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: " + value + "\r\n\r\n",
+			numBytesPerRead: 1,
+		}
+		_, err = RequestFromReader(reader)
+		require.ErrorIs(t, err, ErrBody, value)
+	}
+
+	// Test: Bytes read past Content-Length are kept as buffered
+	src := strings.NewReader("POST /submit HTTP/1.1\r\n" +
+		"Host: localhost:42069\r\n" +
+		"Content-Length: 5\r\n" +
+		"\r\n" +
+		"helloGET / HTTP/1.1\r\n")
+	r, err = RequestFromReader(src)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(r.Body))
+	rest, err := io.ReadAll(src)
+	require.NoError(t, err)
+	assert.Equal(t, "GET / HTTP/1.1\r\n", string(r.Buffered())+string(rest))
+
+	// Test: Bytes after a request without a body are kept as buffered
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n\x81\x85"))
+	require.NoError(t, err)
+	assert.Equal(t, "", string(r.Body))
+	assert.Equal(t, "\x81\x85", string(r.Buffered()))
+}
+
+func TestRequestContext(t *testing.T) {
+	// Test: Parsed request defaults to background context
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, context.Background(), r.Context())
+
+	// Test: WithContext returns a copy carrying the new context
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "req-1")
+	r2 := r.WithContext(ctx)
+	assert.Equal(t, "req-1", r2.Context().Value(key{}))
+	assert.Nil(t, r.Context().Value(key{}))
+	assert.Equal(t, r.RequestLine, r2.RequestLine)
+}
+
+func TestRequestLimits(t *testing.T) {
+	// Test: Headers over the limit
+	reader := &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err := RequestFromReaderWithLimits(reader, Limits{MaxHeaderBytes: 32})
+	require.ErrorIs(t, err, ErrHeaderTooLarge)
+
+	// Test: Headers exactly at the limit
+	data := "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"
+	r, err := RequestFromReaderWithLimits(strings.NewReader(data), Limits{MaxHeaderBytes: len(data)})
+	require.NoError(t, err)
+	assert.Equal(t, "localhost:42069", r.Headers["host"])
+
+	// Test: Headers one byte over the limit, read in one go
+	_, err = RequestFromReaderWithLimits(strings.NewReader(data), Limits{MaxHeaderBytes: len(data) - 1})
+	require.ErrorIs(t, err, ErrHeaderTooLarge)
+
+	// Test: Body over the limit
+	reader = &chunkReader{
+		data:            "POST /submit HTTP/1.1\r\nContent-Length: 13\r\n\r\nhello world!\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReaderWithLimits(reader, Limits{MaxBodyBytes: 12})
+	require.ErrorIs(t, err, ErrBodyTooLarge)
+}
+
+func TestVerifyDigest(t *testing.T) {
+	parse := func(extra string) *Request {
+		t.Helper()
+		r, err := RequestFromReader(&chunkReader{
+			data:            "POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 11\r\n" + extra + "\r\nhello world",
+			numBytesPerRead: 8,
+		})
+		require.NoError(t, err)
+		return r
+	}
+
+	// Test: Matching digests
+	r := parse("Content-Digest: sha-256=:uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek=:\r\n" +
+		"Repr-Digest: sha-512=:MJ7MSJwS1utMxA9QyQLytNDtd+5RGnx6m808qG1M2G+YndNbxf9JlnDaNCVbRbDP2DDoH2Bdz33FVC6TrpzXbw==:\r\n")
+	require.NoError(t, r.VerifyDigest())
+
+	// Test: No digest, nothing to verify
+	require.NoError(t, parse("").VerifyDigest())
+
+	// Test: Mismatch
+	r = parse("Content-Digest: sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:\r\n")
+	require.ErrorIs(t, r.VerifyDigest(), ErrDigestMismatch)
+
+	// Test: Unknown algorithms are skipped, but some must be known
+	r = parse("Content-Digest: md5=:AAAA:, sha-256=:uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek=:\r\n")
+	require.NoError(t, r.VerifyDigest())
+	r = parse("Content-Digest: md5=:AAAA:\r\n")
+	require.ErrorIs(t, r.VerifyDigest(), ErrDigestUnsupported)
+}
+
+func TestRequestAllocs(t *testing.T) {
+	// Test: Parsing allocates the request, its header map, the target and
+	// one string shared by all header values, regardless of header count
+	for _, tc := range []struct {
+		raw    string
+		allocs float64
+	}{
+		{"GET / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n", 4},
+		{browserRequest, 8}, // the header map grows once past 8 entries
+	} {
+		r := strings.NewReader(tc.raw)
+		allocs := testing.AllocsPerRun(100, func() {
+			r.Reset(tc.raw)
+			RequestFromReader(r)
+		})
+		assert.LessOrEqual(t, allocs, tc.allocs)
+	}
+}
+
+// browserRequest is what a typical browser sends for a page.
+const browserRequest = "GET /articles/42?ref=home HTTP/1.1\r\n" +
+	"Host: localhost:42069\r\n" +
+	"User-Agent: Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0\r\n" +
+	"Accept: text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8\r\n" +
+	"Accept-Language: en-US,en;q=0.5\r\n" +
+	"Accept-Encoding: gzip, deflate, br\r\n" +
+	"Connection: keep-alive\r\n" +
+	"Upgrade-Insecure-Requests: 1\r\n" +
+	"Sec-Fetch-Dest: document\r\n" +
+	"Sec-Fetch-Mode: navigate\r\n" +
+	"Sec-Fetch-Site: none\r\n" +
+	"\r\n"
+
+func BenchmarkRequestFromReader(b *testing.B) {
+	for _, bench := range []struct {
+		name string
+		raw  string
+	}{
+		{"curl", "GET / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n"},
+		{"browser", browserRequest},
+		{"post", "POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nContent-Type: application/json\r\nContent-Length: 27\r\n\r\n{\"user_name\": \"Nyan Cat\"}\r\n"},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			r := strings.NewReader(bench.raw)
+			b.SetBytes(int64(len(bench.raw)))
+			b.ReportAllocs()
+			for range b.N {
+				r.Reset(bench.raw)
+				if _, err := RequestFromReader(r); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestCookies(t *testing.T) {
+	// Test: Cookies from every Cookie line
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\nCookie: session=abc123; theme=dark\r\nCookie: lang=en\r\n\r\n"))
+	require.NoError(t, err)
+	cookies := r.Cookies()
+	require.Len(t, cookies, 3)
+	assert.Equal(t, "lang", cookies[2].Name)
+	c, err := r.Cookie("theme")
+	require.NoError(t, err)
+	assert.Equal(t, "dark", c.Value)
+	_, err = r.Cookie("missing")
+	assert.ErrorIs(t, err, cookie.ErrNoCookie)
+
+	// Test: No Cookie header
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Empty(t, r.Cookies())
+}
+
+// formRequest parses a request with the given content type and body.
+func formRequest(t *testing.T, target, contentType, body string) *Request {
+	t.Helper()
+	r, err := RequestFromReader(strings.NewReader(fmt.Sprintf("POST %s HTTP/1.1\r\nHost: localhost:42069\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n%s", target, contentType, len(body), body)))
+	require.NoError(t, err)
+	return r
+}
+
+func TestForm(t *testing.T) {
+	// Test: Url-encoded body and query string
+	r := formRequest(t, "/search?q=query&page=2", "application/x-www-form-urlencoded; charset=utf-8", "q=body+value&tag=a&tag=b%26c")
+	form, err := r.PostForm()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b&c"}, form["tag"])
+	assert.Equal(t, "2", r.Query().Get("page"))
+	assert.Equal(t, "body value", r.FormValue("q"))
+	assert.Equal(t, "2", r.FormValue("page"))
+	assert.Empty(t, r.FormValue("missing"))
+
+	// Test: Other bodies are not forms
+	r = formRequest(t, "/?q=query", "application/json", `{"q": "json"}`)
+	_, err = r.PostForm()
+	assert.ErrorIs(t, err, ErrNotForm)
+	assert.Equal(t, "query", r.FormValue("q"))
+	_, err = r.MultipartForm(FormLimits{})
+	assert.ErrorIs(t, err, ErrNotForm)
+}
+
+// multipartBody builds a form with a value, a small and a big file.
+func multipartBody(t *testing.T, big string) (string, string) {
+	t.Helper()
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+	require.NoError(t, mw.WriteField("title", "holiday"))
+	require.NoError(t, mw.WriteField("tag", "beach"))
+	fw, err := mw.CreateFormFile("photos", "small.txt")
+	require.NoError(t, err)
+	fw.Write([]byte("tiny"))
+	fw, err = mw.CreateFormFile("photos", "big.txt")
+	require.NoError(t, err)
+	fw.Write([]byte(big))
+	require.NoError(t, mw.Close())
+	return mw.FormDataContentType(), b.String()
+}
+
+func TestMultipartForm(t *testing.T) {
+	big := strings.Repeat("0123456789", 100)
+	contentType, body := multipartBody(t, big)
+
+	// Test: Values and files, the big file spooled to disk
+	r := formRequest(t, "/upload", contentType, body)
+	form, err := r.MultipartForm(FormLimits{MaxMemory: 100})
+	require.NoError(t, err)
+	assert.Equal(t, "holiday", form.Value.Get("title"))
+	require.Len(t, form.File["photos"], 2)
+	small, spooled := form.File["photos"][0], form.File["photos"][1]
+	assert.Equal(t, "small.txt", small.Filename)
+	assert.Equal(t, int64(4), small.Size)
+	assert.Empty(t, small.tmpfile)
+	assert.Equal(t, "application/octet-stream", small.Header.Get("Content-Type"))
+	assert.Equal(t, int64(len(big)), spooled.Size)
+	require.NotEmpty(t, spooled.tmpfile)
+	for _, fh := range form.File["photos"] {
+		f, err := fh.Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(f)
+		f.Close()
+		require.NoError(t, err)
+		assert.Len(t, content, int(fh.Size))
+	}
+	require.NoError(t, form.RemoveAll())
+	_, err = os.Stat(spooled.tmpfile)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// Test: Everything fits in memory by default
+	form, err = r.MultipartForm(FormLimits{})
+	require.NoError(t, err)
+	assert.Empty(t, form.File["photos"][1].tmpfile)
+
+	// Test: Values count against MaxMemory, "holiday" and "beach" leave
+	// room for "tiny" in 16 bytes but not in 15
+	form, err = r.MultipartForm(FormLimits{MaxMemory: 16})
+	require.NoError(t, err)
+	assert.Empty(t, form.File["photos"][0].tmpfile)
+	require.NoError(t, form.RemoveAll())
+	form, err = r.MultipartForm(FormLimits{MaxMemory: 15})
+	require.NoError(t, err)
+	assert.NotEmpty(t, form.File["photos"][0].tmpfile)
+	require.NoError(t, form.RemoveAll())
+
+	// Test: Values can't be spooled and fail 10 MiB past MaxMemory
+	var values bytes.Buffer
+	mw := multipart.NewWriter(&values)
+	require.NoError(t, mw.WriteField("a", strings.Repeat("x", 10<<20)))
+	require.NoError(t, mw.WriteField("b", "xx"))
+	require.NoError(t, mw.Close())
+	valuesForm := formRequest(t, "/upload", mw.FormDataContentType(), values.String())
+	_, err = valuesForm.MultipartForm(FormLimits{MaxMemory: 1})
+	assert.ErrorIs(t, err, ErrFormTooLarge)
+	_, err = valuesForm.MultipartForm(FormLimits{MaxMemory: 2})
+	assert.NoError(t, err)
+
+	// Test: Repeated part headers keep their values apart
+	var b bytes.Buffer
+	mw = multipart.NewWriter(&b)
+	fw, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Disposition": {`form-data; name="doc"; filename="a.txt"`},
+		"X-Tag":               {"draft, internal", "review"},
+	})
+	require.NoError(t, err)
+	fw.Write([]byte("text"))
+	require.NoError(t, mw.Close())
+	form, err = formRequest(t, "/upload", mw.FormDataContentType(), b.String()).MultipartForm(FormLimits{})
+	require.NoError(t, err)
+	require.Len(t, form.File["doc"], 1)
+	assert.Equal(t, []string{"draft, internal", "review"}, form.File["doc"][0].Header.Values("X-Tag"))
+
+	// Test: Size limits
+	_, err = r.MultipartForm(FormLimits{MaxPartBytes: 999})
+	assert.ErrorIs(t, err, ErrPartTooLarge)
+	_, err = r.MultipartForm(FormLimits{MaxPartBytes: 1000, MaxFormBytes: 1015})
+	assert.ErrorIs(t, err, ErrFormTooLarge)
+	_, err = r.MultipartForm(FormLimits{MaxPartBytes: 1000, MaxFormBytes: 1016})
+	assert.NoError(t, err)
+
+	// Test: Spooled files are removed when a limit is hit
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	_, err = r.MultipartForm(FormLimits{MaxMemory: 100, MaxFormBytes: 1015})
+	assert.ErrorIs(t, err, ErrFormTooLarge)
+	entries, err := os.ReadDir(tmp)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	// Test: Truncated forms fail
+	r = formRequest(t, "/upload", contentType, body[:len(body)-20])
+	_, err = r.MultipartForm(FormLimits{})
+	assert.Error(t, err)
+
+	// Test: Parts one at a time
+	r = formRequest(t, "/upload", contentType, body)
+	mr, err := r.MultipartReader()
+	require.NoError(t, err)
+	part, err := mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "title", part.FormName())
+}
+
+// seedRequests covers the requests of the tests above plus edge cases the
+// parser has to get right.
+var seedRequests = []string{
+	"GET / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n",
+	"POST /users HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n{\"user_name\": \"Nyan\"}\r\n",
+	"POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 13\r\n\r\nhello world!\n",
+	"POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 20\r\n\r\npartial content",
+	"GET / HTTP/1.1\r\nHost: localhost:42069\r\nSet-Person: lane-loves-go\r\nSet-Person: prime-loves-zig\r\n\r\n",
+	"GET / HTTP/1.1\r\nHost: x\r\nCookie: a=1; b=2\r\nCookie: c=3\r\nSet-Cookie: d=4\r\nSet-Cookie: e=5\r\n\r\n",
+	"GET /coffee HTTP/1.0\r\nHost: localhost:42069\r\n\r\n",
+	"/coffee GET HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+	"GET / HTTP/1.1\r\nHost localhost:42069\r\n\r\n",
+	browserRequest,
+	// pipelined requests
+	"GET /a HTTP/1.1\r\nHost: x\r\n\r\nGET /b HTTP/1.1\r\nHost: x\r\n\r\n",
+	// bare LF, obs-fold and a lone CR
+	"GET / HTTP/1.1\nHost: x\n\n",
+	"GET / HTTP/1.1\r\nHost: x\nX-Injected: yes\r\n\r\n",
+	"GET / HTTP/1.1\r\nHost: x\r\nX-Folded: a\r\n b\r\n\r\n",
+	"GET / HTTP/1.1\r\nHost: x\rX: y\r\n\r\n",
+	// smuggling classics
+	"POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\nContent-Length: 6\r\n\r\nhello!",
+	"POST / HTTP/1.1\r\nHost: x\r\nContent-Length: +5\r\n\r\nhello",
+	"POST / HTTP/1.1\r\nHost: x\r\nContent-Length: -1\r\n\r\nhello",
+	"POST / HTTP/1.1\r\nHost: x\r\nContent-Length: -0\r\n\r\n",
+	"POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 5\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+	"POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 99999999999999999999\r\n\r\n",
+	// whitespace and control characters
+	"GET  / HTTP/1.1\r\nHost: x\r\n\r\n",
+	"GET /\x00 HTTP/1.1\r\nHost: x\r\n\r\n",
+	"GET / HTTP/1.1\r\nHost : x\r\n\r\n",
+	"GET / HTTP/1.1\r\n: empty\r\n\r\n",
+	"GET / HTTP/1.1\r\nX-Nul: a\x00b\r\n\r\n",
+	"GET / HTTP/1.1\r\nX-Tab:\tvalue\t\r\n\r\n",
+	"",
+	"\r\n\r\n",
+}
+
+// fuzzLimits keep memory bounded whatever the input declares.
+var fuzzLimits = Limits{MaxHeaderBytes: 8 << 10, MaxBodyBytes: 64 << 10}
+
+// writeRequest serializes r back into wire format.
+func writeRequest(r *Request) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s HTTP/%s\r\n", r.RequestLine.Method, r.RequestLine.RequestTarget, r.RequestLine.HttpVersion)
+	for _, key := range slices.Sorted(maps.Keys(r.Headers)) {
+		for _, value := range r.Headers.Values(key) {
+			fmt.Fprintf(&b, "%s: %s\r\n", key, value)
+		}
+	}
+	b.WriteString("\r\n")
+	b.Write(r.Body)
+	return b.String()
+}
+
+func FuzzRequestFromReader(f *testing.F) {
+	for _, seed := range seedRequests {
+		f.Add(seed, uint8(3))
+	}
+	f.Fuzz(func(t *testing.T, raw string, chunk uint8) {
+		r, err := RequestFromReaderWithLimits(strings.NewReader(raw), fuzzLimits)
+
+		// the outcome doesn't depend on how the input is split into reads
+		split, splitErr := RequestFromReaderWithLimits(&chunkReader{data: raw, numBytesPerRead: int(chunk%16) + 1}, fuzzLimits)
+		require.Equal(t, err == nil, splitErr == nil, "err=%v splitErr=%v", err, splitErr)
+		if err != nil {
+			return
+		}
+		assert.Equal(t, r.RequestLine, split.RequestLine)
+		assert.Equal(t, r.Headers, split.Headers)
+		assert.Equal(t, r.Body, split.Body)
+
+		// memory stays bounded by the limits
+		assert.LessOrEqual(t, len(r.Body), fuzzLimits.MaxBodyBytes)
+
+		// what was parsed serializes back to an equivalent request
+		again, err := RequestFromReader(strings.NewReader(writeRequest(r)))
+		require.NoError(t, err)
+		assert.Equal(t, r.RequestLine, again.RequestLine)
+		assert.Equal(t, r.Headers, again.Headers)
+		assert.Equal(t, r.Body, again.Body)
+
+		compareWithNetHTTP(t, raw, r)
+	})
+}
+
+// compareWithNetHTTP flags requests that both we and net/http accept but
+// read differently. One-sided rejections are expected: we only speak
+// HTTP/1.1, want uppercase methods and ignore Transfer-Encoding, while
+// net/http is stricter about Content-Length and values. Empty list members
+// are dropped on our side.
+func compareWithNetHTTP(t *testing.T, raw string, r *Request) {
+	t.Helper()
+	// a line starting with whitespace is a new field to us, a continuation
+	// of the previous one (obs-fold) to net/http
+	head, _, _ := strings.Cut(raw, "\r\n\r\n")
+	if strings.Contains(head, "\n ") || strings.Contains(head, "\n\t") {
+		return
+	}
+	theirs, err := http.ReadRequest(bufio.NewReader(strings.NewReader(raw)))
+	if err != nil {
+		return
+	}
+	assert.Equal(t, theirs.Method, r.RequestLine.Method)
+	assert.Equal(t, theirs.RequestURI, r.RequestLine.RequestTarget)
+
+	// net/http moves Transfer-Encoding out of the header map and drops
+	// Content-Length next to it, we don't decode chunked requests at all
+	if _, exists := r.Headers.Get("transfer-encoding"); exists {
+		return
+	}
+	host, hasHost := r.Headers.Get("host")
+	assert.Equal(t, theirs.Host, host)
+	for key, values := range theirs.Header {
+		ours, exists := r.Headers.Get(key)
+		assert.True(t, exists, "header %s", key)
+		values = slices.DeleteFunc(slices.Clone(values), func(v string) bool { return v == "" })
+		sep := ", "
+		switch strings.ToLower(key) {
+		case "set-cookie":
+			sep = "\n"
+		case "cookie":
+			sep = "; "
+		}
+		assert.Equal(t, strings.Join(values, sep), ours, "header %s", key)
+	}
+	want := len(theirs.Header)
+	if hasHost {
+		want++
+	}
+	assert.Len(t, r.Headers, want, "headers %v", r.Headers)
+
+	body, err := io.ReadAll(theirs.Body)
+	if err != nil {
+		return
+	}
+	assert.Equal(t, string(body), string(r.Body))
+}
+
+func FuzzRequestLine(f *testing.F) {
+	for _, seed := range seedRequests {
+		line, _, _ := strings.Cut(seed, "\r\n")
+		f.Add(line)
+	}
+	f.Fuzz(func(t *testing.T, line string) {
+		rl, err := requestLineFromBytes([]byte(line))
+		if err != nil {
+			return
+		}
+		for _, c := range rl.Method {
+			assert.True(t, c >= 'A' && c <= 'Z', "method %q", rl.Method)
+		}
+		assert.NotEmpty(t, rl.RequestTarget)
+		assert.Equal(t, "1.1", rl.HttpVersion)
+		// nothing is dropped or rewritten
+		assert.Equal(t, line, rl.Method+" "+rl.RequestTarget+" HTTP/"+rl.HttpVersion)
+	})
 }
@@ -0,0 +1,44 @@
+package response
+
+import (
+	"io"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/digest"
+	"github.com/DanilShapilov/httpfromtcp/internal/headers"
+)
+
+// SetDigestHeaders sets Content-Digest and Repr-Digest on h for a body sent
+// as is, with each of algs, or sha-256 if none are given. The two fields
+// carry the same value since the writer applies no content coding.
+func SetDigestHeaders(h headers.Headers, body []byte, algs ...digest.Algorithm) error {
+	d, err := digest.NewDigester(defaultDigestAlgs(algs)...)
+	if err != nil {
+		return err
+	}
+	d.Write(body)
+	h.Override("Content-Digest", d.Value())
+	h.Override("Repr-Digest", d.Value())
+	return nil
+}
+
+// DigestTrailers announces Content-Digest and Repr-Digest trailers computed
+// over the chunked body, with each of algs, or sha-256 if none are given.
+// Like ComputeTrailer it must be called before WriteHeaders.
+func (w *Writer) DigestTrailers(algs ...digest.Algorithm) error {
+	d, err := digest.NewDigester(defaultDigestAlgs(algs)...)
+	if err != nil {
+		return err
+	}
+	if err := w.ComputeTrailer("Content-Digest", d, d.Value); err != nil {
+		return err
+	}
+	// the body is already fed to d, Repr-Digest only needs its value
+	return w.ComputeTrailer("Repr-Digest", io.Discard, d.Value)
+}
+
+func defaultDigestAlgs(algs []digest.Algorithm) []digest.Algorithm {
+	if len(algs) == 0 {
+		return []digest.Algorithm{digest.SHA256}
+	}
+	return algs
+}
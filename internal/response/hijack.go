@@ -0,0 +1,47 @@
+package response
+
+import (
+	"errors"
+	"net"
+)
+
+var (
+	ErrNotHijackable = errors.New("connection can't be hijacked")
+	ErrHijacked      = errors.New("connection already hijacked")
+)
+
+// HijackFunc hands the connection over, along with the bytes the server
+// already read from it past the end of the request.
+type HijackFunc func() (net.Conn, []byte, error)
+
+// EnableHijack lets Hijack hand out a connection by calling hijack. The
+// server sets it on every writer it creates.
+func (w *Writer) EnableHijack(hijack HijackFunc) {
+	w.hijack = hijack
+}
+
+// Hijack takes the connection over from the server, for protocols like
+// WebSocket or CONNECT tunnels that stop speaking HTTP once the response
+// headers are out. Besides the connection it returns the bytes the client
+// sent after the request that the server already read; they must be
+// consumed before reading from the connection. The server neither closes,
+// reads from nor reuses the connection afterwards, and the writer refuses
+// any further writes. It must be called before the handler returns.
+func (w *Writer) Hijack() (net.Conn, []byte, error) {
+	if w.writerState == writerStateHijacked {
+		return nil, nil, ErrHijacked
+	}
+	if w.hijack == nil {
+		return nil, nil, ErrNotHijackable
+	}
+	// whatever the handler wrote, like a 101 response, goes out first
+	if err := w.Flush(); err != nil {
+		return nil, nil, err
+	}
+	conn, buffered, err := w.hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.writerState = writerStateHijacked
+	return conn, buffered, nil
+}
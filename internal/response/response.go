@@ -0,0 +1,569 @@
+package response
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/headers"
+)
+
+const readBufferSize = 4096
+
+type ParserState int
+
+const (
+	responseStateStatusLine ParserState = iota
+	responseStateHeaders
+	responseStateBody       // Content-Length delimited
+	responseStateUntilClose // delimited by the server closing the connection
+	responseStateChunkSize
+	responseStateChunkData
+	responseStateChunkEnd // CRLF after chunk data
+	responseStateTrailers
+	responseStateDone
+)
+
+// Limits bounds how much of a response a Reader buffers before giving up.
+type Limits struct {
+	// MaxHeaderBytes caps the status line plus headers, and the trailers,
+	// including CRLFs. It also caps every chunk size line.
+	MaxHeaderBytes int
+}
+
+var (
+	ErrHeaderTooLarge = errors.New("response headers too large")
+
+	// parse errors wrap one of these, so callers can tell what went wrong
+	// with errors.Is
+	ErrStatusLine = errors.New("malformed status line")
+	ErrHeaders    = errors.New("malformed headers")
+	ErrBody       = errors.New("malformed body")
+	ErrIncomplete = errors.New("incomplete response")
+)
+
+type StatusLine struct {
+	HttpVersion  string
+	StatusCode   StatusCode
+	ReasonPhrase string
+}
+
+// Response is a parsed HTTP/1.1 response, the client side counterpart of
+// request.Request.
+type Response struct {
+	StatusLine StatusLine
+	Headers    headers.Headers
+	// Body holds the decoded body, without chunked framing. While a body is
+	// streamed through BodyReader it only holds bytes not handed out yet.
+	Body []byte
+	// Trailers are filled in once a chunked body has been read in full.
+	Trailers headers.Headers
+
+	ParserState ParserState
+
+	// requestMethod matters because responses to HEAD never have a body
+	requestMethod  string
+	bodyLength     int // Content-Length or current chunk size
+	bodyLengthRead int
+	chunked        bool
+	untilClose     bool
+	headerBytes    int // status line, headers and trailers parsed so far
+
+	src *Reader // set while the body is being streamed
+}
+
+func (r *Response) parse(data []byte) (int, error) {
+	if r.ParserState == responseStateDone {
+		return 0, fmt.Errorf("error: trying to read data in a done state")
+	}
+	totalBytesParsed := 0
+	for r.ParserState != responseStateDone {
+		n, err := r.parseSingle(data[totalBytesParsed:])
+		if err != nil {
+			return 0, err
+		}
+		totalBytesParsed += n
+		if n == 0 {
+			break
+		}
+	}
+	return totalBytesParsed, nil
+}
+
+func (r *Response) parseSingle(data []byte) (int, error) {
+	switch r.ParserState {
+	case responseStateStatusLine:
+		sLine, n, err := parseStatusLine(data)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %w", ErrStatusLine, err)
+		}
+		if n == 0 {
+			return 0, nil
+		}
+		r.StatusLine = *sLine
+		r.ParserState = responseStateHeaders
+		r.headerBytes += n
+		return n, nil
+	case responseStateHeaders:
+		n, done, err := r.Headers.Parse(data)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %w", ErrHeaders, err)
+		}
+		r.headerBytes += n
+		if done {
+			if err := r.startBody(); err != nil {
+				return 0, err
+			}
+		}
+		return n, nil
+	case responseStateBody:
+		n := min(len(data), r.bodyLength-r.bodyLengthRead)
+		r.Body = append(r.Body, data[:n]...)
+		r.bodyLengthRead += n
+		if r.bodyLengthRead == r.bodyLength {
+			r.ParserState = responseStateDone
+		}
+		return n, nil
+	case responseStateUntilClose:
+		r.Body = append(r.Body, data...)
+		return len(data), nil
+	case responseStateChunkSize:
+		idx := bytes.Index(data, []byte(crlf))
+		if idx == -1 {
+			return 0, nil
+		}
+		size, err := parseChunkSize(data[:idx])
+		if err != nil {
+			return 0, fmt.Errorf("%w: %w", ErrBody, err)
+		}
+		r.bodyLength = size
+		r.bodyLengthRead = 0
+		if size == 0 {
+			r.ParserState = responseStateTrailers
+		} else {
+			r.ParserState = responseStateChunkData
+		}
+		return idx + len(crlf), nil
+	case responseStateChunkData:
+		n := min(len(data), r.bodyLength-r.bodyLengthRead)
+		r.Body = append(r.Body, data[:n]...)
+		r.bodyLengthRead += n
+		if r.bodyLengthRead == r.bodyLength {
+			r.ParserState = responseStateChunkEnd
+		}
+		return n, nil
+	case responseStateChunkEnd:
+		if len(data) < len(crlf) {
+			return 0, nil
+		}
+		if !bytes.HasPrefix(data, []byte(crlf)) {
+			return 0, fmt.Errorf("%w: missing CRLF after chunk data", ErrBody)
+		}
+		r.ParserState = responseStateChunkSize
+		return len(crlf), nil
+	case responseStateTrailers:
+		n, done, err := r.Trailers.Parse(data)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %w", ErrHeaders, err)
+		}
+		r.headerBytes += n
+		if done {
+			r.ParserState = responseStateDone
+		}
+		return n, nil
+	}
+
+	return 0, fmt.Errorf("error: unknown state")
+}
+
+// startBody picks how the body is delimited once the headers are in.
+func (r *Response) startBody() error {
+	if !r.hasBody() {
+		r.ParserState = responseStateDone
+		return nil
+	}
+	if te, exists := r.Headers.Get("transfer-encoding"); exists {
+		if !strings.EqualFold(lastToken(te), "chunked") {
+			// only the connection closing tells where such a body ends
+			r.untilClose = true
+			r.ParserState = responseStateUntilClose
+			return nil
+		}
+		r.chunked = true
+		r.ParserState = responseStateChunkSize
+		return nil
+	}
+	contentLenStr, exists := r.Headers.Get("content-length")
+	if !exists {
+		// unlike a request, a response without a length runs until the
+		// server closes the connection (RFC 9112 section 6.3)
+		r.untilClose = true
+		r.ParserState = responseStateUntilClose
+		return nil
+	}
+	contentLen, err := strconv.Atoi(contentLenStr)
+	if err != nil || contentLen < 0 {
+		return fmt.Errorf("%w: malformed Content-Length: %s", ErrBody, contentLenStr)
+	}
+	r.bodyLength = contentLen
+	if contentLen == 0 {
+		r.ParserState = responseStateDone
+	} else {
+		r.ParserState = responseStateBody
+	}
+	return nil
+}
+
+// hasBody reports whether the response can carry a body at all (RFC 9112
+// section 6.3).
+func (r *Response) hasBody() bool {
+	if r.requestMethod == "HEAD" {
+		return false
+	}
+	code := r.StatusLine.StatusCode
+	return !(code >= 100 && code < 200 || code == 204 || code == 304)
+}
+
+// ContentLength returns the declared body length, or -1 if the body is
+// chunked, close delimited or the response has none.
+func (r *Response) ContentLength() int {
+	if r.chunked || r.untilClose || !r.hasBody() {
+		return -1
+	}
+	return r.bodyLength
+}
+
+// Complete reports whether the whole response, body and trailers included,
+// has been parsed.
+func (r *Response) Complete() bool {
+	return r.ParserState == responseStateDone
+}
+
+// CloseDelimited reports whether the body ends when the connection does,
+// which rules out reusing the connection.
+func (r *Response) CloseDelimited() bool {
+	return r.untilClose
+}
+
+// closed tells the parser the connection has no more data, which completes
+// a close delimited body. It reports whether that was expected.
+func (r *Response) closed() bool {
+	if r.ParserState == responseStateUntilClose {
+		r.ParserState = responseStateDone
+		return true
+	}
+	return false
+}
+
+// Chunked reports whether the body uses chunked transfer coding.
+func (r *Response) Chunked() bool {
+	return r.chunked
+}
+
+func lastToken(list string) string {
+	if i := strings.LastIndex(list, ","); i != -1 {
+		list = list[i+1:]
+	}
+	return strings.TrimSpace(list)
+}
+
+func parseChunkSize(line []byte) (int, error) {
+	// chunk extensions are allowed and ignored
+	if i := bytes.IndexByte(line, ';'); i != -1 {
+		line = line[:i]
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(string(line)), 16, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid chunk size: %q", line)
+	}
+	return int(size), nil
+}
+
+func parseStatusLine(data []byte) (*StatusLine, int, error) {
+	idx := bytes.Index(data, []byte(crlf))
+	if idx == -1 {
+		return nil, 0, nil
+	}
+	statusLine, err := statusLineFromString(string(data[:idx]))
+	if err != nil {
+		return nil, 0, err
+	}
+	return statusLine, idx + len(crlf), nil
+}
+
+func statusLineFromString(str string) (*StatusLine, error) {
+	// the reason phrase may contain spaces, or be empty
+	parts := strings.SplitN(str, " ", 3)
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("status-line should match following format: HTTP-version SP status-code SP [reason-phrase]")
+	}
+
+	versionParts := strings.Split(parts[0], "/")
+	if len(versionParts) != 2 || versionParts[0] != "HTTP" {
+		return nil, fmt.Errorf("unrecognized HTTP-version: %s", parts[0])
+	}
+	if versionParts[1] != "1.1" && versionParts[1] != "1.0" {
+		return nil, fmt.Errorf("unrecognized HTTP-version: %s", parts[0])
+	}
+
+	if len(parts[1]) != 3 {
+		return nil, fmt.Errorf("invalid status code: %s", parts[1])
+	}
+	code, err := strconv.Atoi(parts[1])
+	if err != nil || code < 100 {
+		return nil, fmt.Errorf("invalid status code: %s", parts[1])
+	}
+
+	reason := ""
+	if len(parts) == 3 {
+		reason = parts[2]
+	}
+	return &StatusLine{
+		HttpVersion:  versionParts[1],
+		StatusCode:   StatusCode(code),
+		ReasonPhrase: reason,
+	}, nil
+}
+
+// ResponseFromReader reads a whole response, body included, from reader.
+// Bytes past the end of the response are lost; use a Reader to read
+// several responses from one connection.
+func ResponseFromReader(reader io.Reader) (*Response, error) {
+	return NewReader(reader).ReadResponse("")
+}
+
+// Reader reads consecutive responses from a connection, keeping the bytes
+// read past the end of one response for the next.
+type Reader struct {
+	reader      io.Reader
+	buf         []byte
+	readToIndex int
+	eof         bool
+	bytesRead   int64
+	limits      Limits
+}
+
+func NewReader(reader io.Reader) *Reader {
+	return NewReaderWithLimits(reader, Limits{})
+}
+
+// NewReaderWithLimits is like NewReader but fails with ErrHeaderTooLarge
+// once a response outgrows limits, rather than buffering whatever the
+// server sends.
+func NewReaderWithLimits(reader io.Reader, limits Limits) *Reader {
+	return &Reader{reader: reader, buf: make([]byte, readBufferSize), limits: limits}
+}
+
+// Buffered returns the number of bytes read from the connection but not
+// parsed yet.
+func (rr *Reader) Buffered() int {
+	return rr.readToIndex
+}
+
+// BytesRead returns the number of bytes read from the connection so far.
+func (rr *Reader) BytesRead() int64 {
+	return rr.bytesRead
+}
+
+// ReadResponse reads a whole response to a request made with
+// requestMethod, which may be empty if it was not HEAD.
+func (rr *Reader) ReadResponse(requestMethod string) (*Response, error) {
+	res := newResponse(requestMethod)
+	if err := rr.parseUntil(res, responseStateDone); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ReadResponseHeader reads a response up to the end of its headers. The body
+// must then be consumed through BodyReader before the next response can be
+// read.
+func (rr *Reader) ReadResponseHeader(requestMethod string) (*Response, error) {
+	res := newResponse(requestMethod)
+	if err := rr.parseUntil(res, responseStateBody); err != nil {
+		return nil, err
+	}
+	res.src = rr
+	return res, nil
+}
+
+func newResponse(requestMethod string) *Response {
+	return &Response{
+		ParserState:   responseStateStatusLine,
+		Headers:       headers.NewHeaders(),
+		Trailers:      headers.NewHeaders(),
+		Body:          make([]byte, 0),
+		requestMethod: requestMethod,
+	}
+}
+
+// parseUntil feeds res until it reaches state or a later one.
+func (rr *Reader) parseUntil(res *Response, state ParserState) error {
+	for {
+		if rr.readToIndex > 0 {
+			numBytesParsed, err := res.parse(rr.buf[:rr.readToIndex])
+			if err != nil {
+				return err
+			}
+			copy(rr.buf, rr.buf[numBytesParsed:rr.readToIndex])
+			rr.readToIndex -= numBytesParsed
+		}
+		if rr.exceedsHeaderLimit(res) {
+			return ErrHeaderTooLarge
+		}
+		if res.ParserState >= state {
+			return nil
+		}
+		if err := rr.fill(); err != nil {
+			if errors.Is(err, io.EOF) && res.closed() {
+				continue
+			}
+			if errors.Is(err, io.EOF) {
+				return ErrIncomplete
+			}
+			return err
+		}
+	}
+}
+
+// exceedsHeaderLimit reports whether the header sections of res, counting
+// the unparsed bytes the parser waits on more data for, break the limit.
+func (rr *Reader) exceedsHeaderLimit(res *Response) bool {
+	limit := rr.limits.MaxHeaderBytes
+	if limit <= 0 {
+		return false
+	}
+	if res.headerBytes > limit {
+		return true
+	}
+	switch res.ParserState {
+	case responseStateStatusLine, responseStateHeaders, responseStateTrailers:
+		return res.headerBytes+rr.readToIndex > limit
+	case responseStateChunkSize, responseStateChunkEnd:
+		return rr.readToIndex > limit
+	}
+	return false
+}
+
+// fill reads more data from the connection into the buffer.
+func (rr *Reader) fill() error {
+	if rr.eof {
+		return io.EOF
+	}
+	if rr.readToIndex >= len(rr.buf) {
+		newBuf := make([]byte, len(rr.buf)*2)
+		copy(newBuf, rr.buf)
+		rr.buf = newBuf
+	}
+	n, err := rr.reader.Read(rr.buf[rr.readToIndex:])
+	rr.readToIndex += n
+	rr.bytesRead += int64(n)
+	if errors.Is(err, io.EOF) {
+		rr.eof = true
+		if n > 0 {
+			return nil
+		}
+	}
+	return err
+}
+
+// BodyReader returns a reader streaming the decoded body of a response read
+// with ReadResponseHeader. It returns io.EOF once the body, and trailers if
+// any, have been read. For responses read with ReadResponse it simply
+// reads Body.
+func (r *Response) BodyReader() io.Reader {
+	return &bodyReader{res: r}
+}
+
+type bodyReader struct {
+	res *Response
+}
+
+func (br *bodyReader) Read(p []byte) (int, error) {
+	res := br.res
+	for len(res.Body) == 0 {
+		if res.ParserState == responseStateDone || res.src == nil {
+			return 0, io.EOF
+		}
+		if err := res.src.parseStep(res); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, res.Body)
+	res.Body = res.Body[n:]
+	return n, nil
+}
+
+// WriteTo copies the rest of the body to dst. Once the buffered bytes are
+// out, the rest of a Content-Length body is copied from the connection
+// directly, which lets dst splice it when both are TCP connections.
+func (br *bodyReader) WriteTo(dst io.Writer) (int64, error) {
+	res := br.res
+	var total int64
+	for {
+		if len(res.Body) > 0 {
+			n, err := dst.Write(res.Body)
+			total += int64(n)
+			res.Body = res.Body[n:]
+			if err != nil {
+				return total, err
+			}
+		}
+		if res.ParserState == responseStateDone || res.src == nil {
+			return total, nil
+		}
+		rr := res.src
+		if res.ParserState == responseStateBody && rr.readToIndex == 0 && !rr.eof {
+			remaining := int64(res.bodyLength - res.bodyLengthRead)
+			n, err := io.Copy(dst, &io.LimitedReader{R: rr.reader, N: remaining})
+			total += n
+			rr.bytesRead += n
+			res.bodyLengthRead += int(n)
+			if res.bodyLengthRead == res.bodyLength {
+				res.ParserState = responseStateDone
+			}
+			if err != nil {
+				return total, err
+			}
+			if n < remaining {
+				return total, ErrIncomplete
+			}
+			continue
+		}
+		if err := rr.parseStep(res); err != nil {
+			return total, err
+		}
+	}
+}
+
+// parseStep makes progress on res by at least one read from the
+// connection, unless buffered bytes are enough.
+func (rr *Reader) parseStep(res *Response) error {
+	if rr.readToIndex > 0 {
+		numBytesParsed, err := res.parse(rr.buf[:rr.readToIndex])
+		if err != nil {
+			return err
+		}
+		copy(rr.buf, rr.buf[numBytesParsed:rr.readToIndex])
+		rr.readToIndex -= numBytesParsed
+		if rr.exceedsHeaderLimit(res) {
+			return ErrHeaderTooLarge
+		}
+		if numBytesParsed > 0 || res.ParserState == responseStateDone {
+			return nil
+		}
+	}
+	if err := rr.fill(); err != nil {
+		if errors.Is(err, io.EOF) && res.closed() {
+			return nil
+		}
+		if errors.Is(err, io.EOF) {
+			return ErrIncomplete
+		}
+		return err
+	}
+	return nil
+}
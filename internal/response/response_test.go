@@ -0,0 +1,713 @@
+package response
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/digest"
+	"github.com/DanilShapilov/httpfromtcp/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type chunkReader struct {
+	data            string
+	numBytesPerRead int
+	pos             int
+}
+
+// Read reads up to len(p) or numBytesPerRead bytes from the string per call
+// its useful for simulating reading a variable number of bytes per chunk from a network connection
+func (cr *chunkReader) Read(p []byte) (n int, err error) {
+	if cr.pos >= len(cr.data) {
+		return 0, io.EOF
+	}
+	endIndex := cr.pos + cr.numBytesPerRead
+	if endIndex > len(cr.data) {
+		endIndex = len(cr.data)
+	}
+	n = copy(p, cr.data[cr.pos:endIndex])
+	cr.pos += n
+
+	return n, nil
+}
+
+func TestStatusLineParse(t *testing.T) {
+	// Test: Good status line
+	reader := &chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := ResponseFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "1.1", r.StatusLine.HttpVersion)
+	assert.Equal(t, StatusCodeSuccess, r.StatusLine.StatusCode)
+	assert.Equal(t, "OK", r.StatusLine.ReasonPhrase)
+
+	// Test: Reason phrase with spaces
+	reader = &chunkReader{
+		data:            "HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n",
+		numBytesPerRead: 1,
+	}
+	r, err = ResponseFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCodeNotFound, r.StatusLine.StatusCode)
+	assert.Equal(t, "Not Found", r.StatusLine.ReasonPhrase)
+
+	// Test: Empty reason phrase, with and without the space
+	for _, line := range []string{"HTTP/1.1 204 \r\n\r\n", "HTTP/1.1 204\r\n\r\n"} {
+		r, err = ResponseFromReader(&chunkReader{data: line, numBytesPerRead: 8})
+		require.NoError(t, err)
+		assert.Equal(t, StatusCode(204), r.StatusLine.StatusCode)
+		assert.Equal(t, "", r.StatusLine.ReasonPhrase)
+	}
+
+	// Test: HTTP/1.0
+	r, err = ResponseFromReader(&chunkReader{data: "HTTP/1.0 200 OK\r\nContent-Length: 0\r\n\r\n", numBytesPerRead: 8})
+	require.NoError(t, err)
+	assert.Equal(t, "1.0", r.StatusLine.HttpVersion)
+
+	// Test: Invalid status lines
+	for _, line := range []string{
+		"HTTP/2 200 OK\r\n\r\n",
+		"HTTP/1.1 20 OK\r\n\r\n",
+		"HTTP/1.1 abc OK\r\n\r\n",
+		"200 OK HTTP/1.1\r\n\r\n",
+		"HTTP/1.1\r\n\r\n",
+	} {
+		_, err = ResponseFromReader(&chunkReader{data: line, numBytesPerRead: 8})
+		require.ErrorIs(t, err, ErrStatusLine, line)
+	}
+}
+
+func TestResponseBody(t *testing.T) {
+	// Test: Content-Length body
+	reader := &chunkReader{
+		data: "HTTP/1.1 200 OK\r\n" +
+			"Content-Type: text/plain\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n" +
+			"hello world!\n",
+		numBytesPerRead: 3,
+	}
+	r, err := ResponseFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(r.Body))
+	assert.Equal(t, 13, r.ContentLength())
+	contentType, _ := r.Headers.Get("content-type")
+	assert.Equal(t, "text/plain", contentType)
+
+	// Test: Body shorter than reported content length
+	reader = &chunkReader{
+		data: "HTTP/1.1 200 OK\r\n" +
+			"Content-Length: 20\r\n" +
+			"\r\n" +
+			"partial content",
+		numBytesPerRead: 3,
+	}
+	_, err = ResponseFromReader(reader)
+	require.ErrorIs(t, err, ErrIncomplete)
+
+	// Test: Chunked body with extensions and trailers
+	reader = &chunkReader{
+		data: "HTTP/1.1 200 OK\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Trailer: X-Checksum\r\n" +
+			"\r\n" +
+			"6\r\nhello \r\n" +
+			"5;name=value\r\nworld\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc123\r\n" +
+			"\r\n",
+		numBytesPerRead: 4,
+	}
+	r, err = ResponseFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(r.Body))
+	assert.True(t, r.Chunked())
+	assert.Equal(t, -1, r.ContentLength())
+	checksum, _ := r.Trailers.Get("x-checksum")
+	assert.Equal(t, "abc123", checksum)
+
+	// Test: Malformed chunks
+	for _, body := range []string{"zz\r\nhello\r\n0\r\n\r\n", "5\r\nhelloXX0\r\n\r\n"} {
+		reader = &chunkReader{
+			data:            "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" + body,
+			numBytesPerRead: 4,
+		}
+		_, err = ResponseFromReader(reader)
+		require.ErrorIs(t, err, ErrBody)
+	}
+
+	// Test: Body delimited by the connection closing
+	reader = &chunkReader{
+		data: "HTTP/1.1 200 OK\r\n" +
+			"Content-Type: text/plain\r\n" +
+			"\r\n" +
+			"read until close",
+		numBytesPerRead: 5,
+	}
+	r, err = ResponseFromReader(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "read until close", string(r.Body))
+	assert.True(t, r.CloseDelimited())
+	assert.Equal(t, -1, r.ContentLength())
+
+	// Test: Responses without a body
+	for _, raw := range []string{
+		"HTTP/1.1 204 No Content\r\n\r\n",
+		"HTTP/1.1 304 Not Modified\r\nContent-Length: 42\r\n\r\n",
+		"HTTP/1.1 100 Continue\r\n\r\n",
+	} {
+		r, err = ResponseFromReader(&chunkReader{data: raw, numBytesPerRead: 4})
+		require.NoError(t, err)
+		assert.Empty(t, r.Body)
+		assert.False(t, r.CloseDelimited())
+	}
+
+	// Test: Responses to HEAD have no body despite their Content-Length
+	raw := "HTTP/1.1 200 OK\r\nContent-Length: 42\r\n\r\n"
+	r, err = NewReader(&chunkReader{data: raw, numBytesPerRead: 4}).ReadResponse("HEAD")
+	require.NoError(t, err)
+	assert.Empty(t, r.Body)
+	assert.Equal(t, -1, r.ContentLength())
+}
+
+func TestReader(t *testing.T) {
+	// Test: Consecutive responses on one connection
+	rr := NewReader(&chunkReader{
+		data: "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nfirst" +
+			"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n6\r\nsecond\r\n0\r\n\r\n" +
+			"HTTP/1.1 204 No Content\r\n\r\n",
+		numBytesPerRead: 7,
+	})
+	r, err := rr.ReadResponse("")
+	require.NoError(t, err)
+	assert.Equal(t, "first", string(r.Body))
+	r, err = rr.ReadResponse("")
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(r.Body))
+	r, err = rr.ReadResponse("")
+	require.NoError(t, err)
+	assert.Equal(t, StatusCode(204), r.StatusLine.StatusCode)
+	assert.Equal(t, 0, rr.Buffered())
+
+	// Test: Streaming a body from the headers on
+	rr = NewReader(&chunkReader{
+		data: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" +
+			"3\r\nabc\r\n3\r\ndef\r\n0\r\nX-Done: yes\r\n\r\n" +
+			"HTTP/1.1 200 OK\r\nContent-Length: 4\r\n\r\nnext",
+		numBytesPerRead: 2,
+	})
+	r, err = rr.ReadResponseHeader("")
+	require.NoError(t, err)
+	body, err := io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, "abcdef", string(body))
+	done, _ := r.Trailers.Get("x-done")
+	assert.Equal(t, "yes", done)
+	r, err = rr.ReadResponse("")
+	require.NoError(t, err)
+	assert.Equal(t, "next", string(r.Body))
+
+	// Test: A truncated streamed body is an error
+	rr = NewReader(&chunkReader{
+		data:            "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nshort",
+		numBytesPerRead: 2,
+	})
+	r, err = rr.ReadResponseHeader("")
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.BodyReader())
+	require.ErrorIs(t, err, ErrIncomplete)
+
+	// Test: io.Copy takes the rest of a body straight from the connection
+	data := "HTTP/1.1 200 OK\r\nContent-Length: 1000\r\n\r\n" + strings.Repeat("x", 1000) +
+		"HTTP/1.1 204 No Content\r\n\r\n"
+	rr = NewReader(&chunkReader{data: data, numBytesPerRead: 64})
+	r, err = rr.ReadResponseHeader("")
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1000), n)
+	assert.Equal(t, strings.Repeat("x", 1000), buf.String())
+	assert.True(t, r.Complete())
+	r, err = rr.ReadResponse("")
+	require.NoError(t, err)
+	assert.Equal(t, StatusCode(204), r.StatusLine.StatusCode)
+	assert.Equal(t, int64(len(data)), rr.BytesRead())
+}
+
+// endless is a connection that never stops sending the same byte.
+type endless byte
+
+func (e endless) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(e)
+	}
+	return len(p), nil
+}
+
+func TestReaderLimits(t *testing.T) {
+	limits := Limits{MaxHeaderBytes: 1024}
+
+	// Test: Endless status lines, header lines and chunk size lines stop
+	// at the limit
+	for _, start := range []string{
+		"HTTP/1.1 200 ",
+		"HTTP/1.1 200 OK\r\nX-Long: ",
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n",
+	} {
+		rr := NewReaderWithLimits(io.MultiReader(strings.NewReader(start), endless('1')), limits)
+		_, err := rr.ReadResponse("")
+		require.ErrorIs(t, err, ErrHeaderTooLarge, start)
+		assert.LessOrEqual(t, rr.BytesRead(), int64(limits.MaxHeaderBytes+readBufferSize), start)
+	}
+
+	// Test: Many short header lines count together, even read at once
+	raw := "HTTP/1.1 200 OK\r\n" + strings.Repeat("X-A: b\r\n", 200) + "\r\n"
+	_, err := NewReaderWithLimits(strings.NewReader(raw), limits).ReadResponse("")
+	require.ErrorIs(t, err, ErrHeaderTooLarge)
+
+	// Test: Trailers count too
+	raw = "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n" + strings.Repeat("X-A: b\r\n", 200) + "\r\n"
+	_, err = NewReaderWithLimits(strings.NewReader(raw), limits).ReadResponse("")
+	require.ErrorIs(t, err, ErrHeaderTooLarge)
+
+	// Test: Bodies are not limited
+	raw = "HTTP/1.1 200 OK\r\nContent-Length: 5000\r\n\r\n" + strings.Repeat("x", 5000)
+	r, err := NewReaderWithLimits(&chunkReader{data: raw, numBytesPerRead: 700}, limits).ReadResponse("")
+	require.NoError(t, err)
+	assert.Len(t, r.Body, 5000)
+}
+
+func TestWriter(t *testing.T) {
+	// Test: Status line, headers and body round trip
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	body := []byte("hello")
+	require.NoError(t, w.WriteStatusLine(StatusCodeNotFound))
+	h := GetDefaultHeaders(len(body))
+	h.Set("X-Custom", "yes")
+	require.NoError(t, w.WriteHeaders(h))
+	_, err := w.WriteBody(body)
+	require.NoError(t, err)
+
+	r, err := ResponseFromReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCodeNotFound, r.StatusLine.StatusCode)
+	assert.Equal(t, "Not Found", r.StatusLine.ReasonPhrase)
+	assert.Equal(t, "hello", string(r.Body))
+	custom, _ := r.Headers.Get("x-custom")
+	assert.Equal(t, "yes", custom)
+	connection, _ := r.Headers.Get("connection")
+	assert.Equal(t, "close", connection)
+	assert.Equal(t, StatusCodeNotFound, w.StatusCode())
+	assert.Equal(t, 5, w.BytesWritten())
+
+	// Test: Set-Cookie lines are written apart
+	buf.Reset()
+	w = NewWriter(&buf)
+	h = GetDefaultHeaders(0)
+	h.Set("Set-Cookie", "a=1; Expires=Wed, 01 May 2024 12:00:00 GMT")
+	h.Set("Set-Cookie", "b=2")
+	require.NoError(t, w.WriteStatusLine(StatusCodeSuccess))
+	require.NoError(t, w.WriteHeaders(h))
+	assert.Contains(t, buf.String(), "\r\nset-cookie: a=1; Expires=Wed, 01 May 2024 12:00:00 GMT\r\n")
+	assert.Contains(t, buf.String(), "\r\nset-cookie: b=2\r\n")
+	r, err = ResponseFromReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, h.Values("set-cookie"), r.Headers.Values("set-cookie"))
+
+	// Test: Header hooks change a copy of the headers
+	buf.Reset()
+	w = NewWriter(&buf)
+	h = GetDefaultHeaders(0)
+	w.OnWriteHeaders(func(h headers.Headers) { h.Set("X-Hook", "1") })
+	w.OnWriteHeaders(func(h headers.Headers) { h.Set("X-Hook", "2") })
+	require.NoError(t, w.WriteStatusLine(StatusCodeSuccess))
+	require.NoError(t, w.WriteHeaders(h))
+	r, err = ResponseFromReader(&buf)
+	require.NoError(t, err)
+	hook, _ := r.Headers.Get("x-hook")
+	assert.Equal(t, "1, 2", hook)
+	_, exists := h.Get("x-hook")
+	assert.False(t, exists)
+
+	// Test: Chunked body and trailers round trip
+	buf.Reset()
+	w = NewWriter(&buf)
+	h = GetDefaultHeaders(0)
+	h.Remove("Content-Length")
+	h.Override("Transfer-Encoding", "chunked")
+	h.Override("Trailer", "X-Checksum")
+	require.NoError(t, w.WriteStatusLine(StatusCodeSuccess))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteChunkedBody([]byte("hello "))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBody([]byte("world"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Checksum", "abc123")
+	require.NoError(t, w.WriteTrailers(trailers))
+
+	r, err = ResponseFromReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(r.Body))
+	checksum, _ := r.Trailers.Get("x-checksum")
+	assert.Equal(t, "abc123", checksum)
+	assert.Equal(t, 11, w.BytesWritten())
+
+	// Test: Out of order calls are rejected
+	w = NewWriter(io.Discard)
+	require.Error(t, w.WriteHeaders(GetDefaultHeaders(0)))
+	_, err = w.WriteBody([]byte("x"))
+	require.Error(t, err)
+}
+
+func chunkedWriter(buf *bytes.Buffer, trailer string) (*Writer, headers.Headers) {
+	w := NewWriter(buf)
+	h := GetDefaultHeaders(0)
+	h.Remove("Content-Length")
+	h.Override("Transfer-Encoding", "chunked")
+	if trailer != "" {
+		h.Override("Trailer", trailer)
+	}
+	return w, h
+}
+
+func FuzzWriter(f *testing.F) {
+	f.Add("Content-Type: text/plain\r\nX-Custom: yes\r\n\r\n", []byte("hello"), uint8(0))
+	f.Add("Set-Person: lane-loves-go\r\nSet-Person: prime-loves-zig\r\n\r\n", []byte{}, uint8(8))
+	f.Add("Transfer-Encoding: chunked\r\nContent-Length: 99\r\n\r\n", []byte("0\r\n\r\n"), uint8(1))
+	f.Add("Connection: keep-alive\r\nX-Tab:\tvalue\t\r\n\r\n", bytes.Repeat([]byte("x"), 5000), uint8(255))
+	f.Fuzz(func(t *testing.T, head string, body []byte, size uint8) {
+		// anything the header parser accepts must be written back intact
+		h := headers.NewHeaders()
+		data := []byte(head)
+		for len(data) > 0 {
+			n, done, err := h.Parse(data)
+			if err != nil || n == 0 || done {
+				break
+			}
+			data = data[n:]
+		}
+		h.Remove("Transfer-Encoding")
+		h.Remove("Trailer")
+		h.Override("Content-Length", strconv.Itoa(len(body)))
+
+		var buf bytes.Buffer
+		w := NewWriterSize(&buf, int(size))
+		require.NoError(t, w.WriteStatusLine(StatusCodeSuccess))
+		require.NoError(t, w.WriteHeaders(h))
+		_, err := w.WriteBody(body)
+		require.NoError(t, err)
+		require.NoError(t, w.Flush())
+
+		r, err := ResponseFromReader(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, StatusCodeSuccess, r.StatusLine.StatusCode)
+		assert.Equal(t, h, r.Headers)
+		assert.Equal(t, body, r.Body)
+		assert.Zero(t, buf.Len())
+	})
+}
+
+func TestTrailers(t *testing.T) {
+	// Test: Undeclared and forbidden trailers are rejected
+	var buf bytes.Buffer
+	w, h := chunkedWriter(&buf, "X-Checksum, Content-Length")
+	require.NoError(t, w.WriteStatusLine(StatusCodeSuccess))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err := w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Other", "1")
+	require.Error(t, w.WriteTrailers(trailers))
+	trailers = headers.NewHeaders()
+	trailers.Set("Content-Length", "0")
+	require.Error(t, w.WriteTrailers(trailers))
+
+	// Test: Nothing can be written after the trailers
+	trailers = headers.NewHeaders()
+	trailers.Set("X-Checksum", "abc123")
+	require.NoError(t, w.WriteTrailers(trailers))
+	_, err = w.WriteChunkedBody([]byte("more"))
+	require.Error(t, err)
+	_, err = w.WriteBody([]byte("more"))
+	require.Error(t, err)
+	require.Error(t, w.WriteTrailers(headers.NewHeaders()))
+
+	// Test: Computed SHA-256 trailer is announced and sent
+	buf.Reset()
+	w, h = chunkedWriter(&buf, "")
+	require.NoError(t, w.SHA256Trailer("X-Content-SHA256"))
+	require.NoError(t, w.WriteStatusLine(StatusCodeSuccess))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteChunkedBody([]byte("hello "))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBody([]byte("world"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(nil))
+	_, exists := h.Get("trailer")
+	assert.False(t, exists, "caller's headers are left alone")
+
+	r, err := ResponseFromReader(&buf)
+	require.NoError(t, err)
+	announced, _ := r.Headers.Get("trailer")
+	assert.Equal(t, "X-Content-SHA256", announced)
+	sum, _ := r.Trailers.Get("x-content-sha256")
+	assert.Equal(t, "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9", sum)
+
+	// Test: Computed trailers can't be set by hand or be forbidden fields
+	w, h = chunkedWriter(&buf, "")
+	require.Error(t, w.SHA256Trailer("Content-Type"))
+	require.NoError(t, w.SHA256Trailer("X-Sum"))
+	require.NoError(t, w.WriteStatusLine(StatusCodeSuccess))
+	require.NoError(t, w.WriteHeaders(h))
+	require.Error(t, w.SHA256Trailer("X-Late"))
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	trailers = headers.NewHeaders()
+	trailers.Set("X-Sum", "forged")
+	require.Error(t, w.WriteTrailers(trailers))
+}
+
+func TestDigest(t *testing.T) {
+	// Test: Digest headers for a body of known length
+	h := GetDefaultHeaders(11)
+	require.NoError(t, SetDigestHeaders(h, []byte("hello world")))
+	contentDigest, _ := h.Get("content-digest")
+	reprDigest, _ := h.Get("repr-digest")
+	assert.Equal(t, "sha-256=:uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek=:", contentDigest)
+	assert.Equal(t, contentDigest, reprDigest)
+
+	// Test: Digest trailers for a chunked body
+	var buf bytes.Buffer
+	w, h := chunkedWriter(&buf, "")
+	require.NoError(t, w.DigestTrailers(digest.SHA256, digest.SHA512))
+	require.NoError(t, w.WriteStatusLine(StatusCodeSuccess))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err := w.WriteChunkedBody([]byte("hello "))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBody([]byte("world"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(nil))
+
+	r, err := ResponseFromReader(&buf)
+	require.NoError(t, err)
+	announced, _ := r.Headers.Get("trailer")
+	assert.Equal(t, "Content-Digest, Repr-Digest", announced)
+	want := "sha-256=:uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek=:, " +
+		"sha-512=:MJ7MSJwS1utMxA9QyQLytNDtd+5RGnx6m808qG1M2G+YndNbxf9JlnDaNCVbRbDP2DDoH2Bdz33FVC6TrpzXbw==:"
+	contentDigest, _ = r.Trailers.Get("content-digest")
+	reprDigest, _ = r.Trailers.Get("repr-digest")
+	assert.Equal(t, want, contentDigest)
+	assert.Equal(t, want, reprDigest)
+
+	// Test: Unsupported algorithm
+	require.Error(t, SetDigestHeaders(h, nil, "md5"))
+}
+
+// countingWriter counts the writes that reach it.
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.writes++
+	return cw.Buffer.Write(p)
+}
+
+// writevWriter takes net.Buffers in a single call, like a connection.
+type writevWriter struct{ *countingWriter }
+
+func (ww writevWriter) WriteBuffers(v *net.Buffers) (int64, error) {
+	ww.writes++
+	return v.WriteTo(&ww.Buffer)
+}
+
+type failingWriter struct{ err error }
+
+func (fw failingWriter) Write(p []byte) (int, error) { return 0, fw.err }
+
+func TestBufferedWriter(t *testing.T) {
+	// Test: Output is held back until Flush and sent in a single write
+	cw := &countingWriter{}
+	w := NewWriterSize(cw, 1024)
+	h := GetDefaultHeaders(0)
+	h.Remove("Content-Length")
+	h.Override("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteStatusLine(StatusCodeSuccess))
+	require.NoError(t, w.WriteHeaders(h))
+	for _, chunk := range []string{"hello", " ", "world"} {
+		_, err := w.WriteChunkedBody([]byte(chunk))
+		require.NoError(t, err)
+	}
+	_, err := w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	require.NoError(t, w.WriteTrailers(headers.NewHeaders()))
+	assert.Equal(t, 0, cw.writes)
+	require.NoError(t, w.Flush())
+	assert.Equal(t, 1, cw.writes)
+	r, err := ResponseFromReader(&cw.Buffer)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(r.Body))
+
+	// Test: Unbuffered writers send each chunk in a single write
+	cw = &countingWriter{}
+	w = NewWriter(cw)
+	require.NoError(t, w.WriteStatusLine(StatusCodeSuccess))
+	require.NoError(t, w.WriteHeaders(h))
+	cw.writes = 0
+	_, err = w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, cw.writes)
+	assert.True(t, bytes.HasSuffix(cw.Bytes(), []byte("\r\n5\r\nhello\r\n")))
+
+	// Test: Empty chunks don't end the body
+	n, err := w.WriteChunkedBody(nil)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	assert.Equal(t, 1, cw.writes)
+
+	// Test: Bodies bigger than the buffer go out with the buffered output in one writev
+	cw = &countingWriter{}
+	w = NewWriterSize(writevWriter{cw}, 1024)
+	body := bytes.Repeat([]byte("x"), 10000)
+	require.NoError(t, w.WriteStatusLine(StatusCodeSuccess))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(len(body))))
+	_, err = w.WriteBody(body)
+	require.NoError(t, err)
+	assert.Equal(t, 1, cw.writes)
+	r, err = ResponseFromReader(&cw.Buffer)
+	require.NoError(t, err)
+	assert.Equal(t, body, r.Body)
+	assert.Equal(t, len(body), w.BytesWritten())
+
+	// Test: Write errors stick
+	fail := errors.New("connection reset")
+	w = NewWriterSize(failingWriter{fail}, 1024)
+	require.NoError(t, w.WriteStatusLine(StatusCodeSuccess))
+	require.ErrorIs(t, w.Flush(), fail)
+	require.ErrorIs(t, w.WriteHeaders(h), fail)
+}
+
+// loopback returns the client side of a TCP connection whose server side
+// discards everything.
+func loopback(b *testing.B) net.Conn {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(b, err)
+	b.Cleanup(func() { l.Close() })
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(io.Discard, conn)
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(b, err)
+	b.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func BenchmarkWriteChunkedBody(b *testing.B) {
+	for _, bench := range []struct {
+		name      string
+		bufSize   int
+		chunkSize int
+	}{
+		{"unbuffered/128B", 0, 128},
+		{"buffered/128B", DefaultBufferSize, 128},
+		{"unbuffered/64KB", 0, 64 << 10},
+		{"buffered/64KB", DefaultBufferSize, 64 << 10},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			w := NewWriterSize(loopback(b), bench.bufSize)
+			h := GetDefaultHeaders(0)
+			h.Remove("Content-Length")
+			h.Override("Transfer-Encoding", "chunked")
+			w.WriteStatusLine(StatusCodeSuccess)
+			w.WriteHeaders(h)
+			chunk := bytes.Repeat([]byte("x"), bench.chunkSize)
+			b.SetBytes(int64(len(chunk)))
+			b.ResetTimer()
+			for range b.N {
+				if _, err := w.WriteChunkedBody(chunk); err != nil {
+					b.Fatal(err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}
+
+func BenchmarkWriteHeaders(b *testing.B) {
+	h := GetDefaultHeaders(1024)
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Request-Id", "0af7651916cd43dd8448eb211c80319c")
+	b.ReportAllocs()
+	for range b.N {
+		w := NewWriterSize(io.Discard, DefaultBufferSize)
+		w.WriteStatusLine(StatusCodeSuccess)
+		w.WriteHeaders(h)
+		w.Flush()
+	}
+}
+
+func BenchmarkReadFrom(b *testing.B) {
+	name := filepath.Join(b.TempDir(), "body")
+	require.NoError(b, os.WriteFile(name, bytes.Repeat([]byte("x"), 1<<20), 0o644))
+	f, err := os.Open(name)
+	require.NoError(b, err)
+	b.Cleanup(func() { f.Close() })
+
+	for _, bench := range []struct {
+		name string
+		wrap func(net.Conn) io.Writer
+	}{
+		{"sendfile", func(c net.Conn) io.Writer { return c }},
+		// hiding ReadFrom is what a TLS connection amounts to
+		{"copy", func(c net.Conn) io.Writer { return struct{ io.Writer }{c} }},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			conn := bench.wrap(loopback(b))
+			h := GetDefaultHeaders(1 << 20)
+			b.SetBytes(1 << 20)
+			b.ResetTimer()
+			for range b.N {
+				w := NewWriterSize(conn, DefaultBufferSize)
+				w.WriteStatusLine(StatusCodeSuccess)
+				w.WriteHeaders(h)
+				if _, err := f.Seek(0, io.SeekStart); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := w.ReadFrom(f); err != nil {
+					b.Fatal(err)
+				}
+				if err := w.Flush(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
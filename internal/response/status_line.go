@@ -7,16 +7,62 @@ import (
 type StatusCode int
 
 const (
-	StatusCodeSuccess             StatusCode = 200
-	StatusCodeBadRequest          StatusCode = 400
-	StatusCodeInternalServerError StatusCode = 500
+	StatusCodeSwitchingProtocols          StatusCode = 101
+	StatusCodeSuccess                     StatusCode = 200
+	StatusCodePartialContent              StatusCode = 206
+	StatusCodeBadRequest                  StatusCode = 400
+	StatusCodeForbidden                   StatusCode = 403
+	StatusCodeNotFound                    StatusCode = 404
+	StatusCodeMethodNotAllowed            StatusCode = 405
+	StatusCodeContentTooLarge             StatusCode = 413
+	StatusCodeRangeNotSatisfiable         StatusCode = 416
+	StatusCodeUpgradeRequired             StatusCode = 426
+	StatusCodeRequestHeaderFieldsTooLarge StatusCode = 431
+	StatusCodeInternalServerError         StatusCode = 500
+	StatusCodeBadGateway                  StatusCode = 502
+	StatusCodeServiceUnavailable          StatusCode = 503
+	StatusCodeGatewayTimeout              StatusCode = 504
 )
 
+var reasonPhrases = map[StatusCode]string{
+	100: "Continue",
+	101: "Switching Protocols",
+	200: "OK",
+	201: "Created",
+	202: "Accepted",
+	204: "No Content",
+	206: "Partial Content",
+	301: "Moved Permanently",
+	302: "Found",
+	303: "See Other",
+	304: "Not Modified",
+	307: "Temporary Redirect",
+	308: "Permanent Redirect",
+	400: "Bad Request",
+	401: "Unauthorized",
+	403: "Forbidden",
+	404: "Not Found",
+	405: "Method Not Allowed",
+	408: "Request Timeout",
+	409: "Conflict",
+	413: "Content Too Large",
+	416: "Range Not Satisfiable",
+	426: "Upgrade Required",
+	429: "Too Many Requests",
+	431: "Request Header Fields Too Large",
+	500: "Internal Server Error",
+	501: "Not Implemented",
+	502: "Bad Gateway",
+	503: "Service Unavailable",
+	504: "Gateway Timeout",
+}
+
+// ReasonPhrase returns the standard reason phrase for statusCode, or "" for
+// codes this package doesn't know. An empty reason is valid HTTP/1.1.
+func ReasonPhrase(statusCode StatusCode) string {
+	return reasonPhrases[statusCode]
+}
+
 func getStatusLine(statusCode StatusCode) []byte {
-	var reasonPhrases = map[StatusCode]string{
-		200: "OK",
-		400: "Bad Request",
-		500: "Internal Server Error",
-	}
 	return []byte(fmt.Sprintf("HTTP/1.1 %d %s%s", statusCode, reasonPhrases[statusCode], crlf))
 }
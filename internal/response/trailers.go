@@ -0,0 +1,123 @@
+package response
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/headers"
+)
+
+// forbiddenTrailers are fields a recipient needs before the body, or that
+// control framing, routing, authentication or caching, so they can't be
+// sent after it (RFC 9110 section 6.5.1).
+var forbiddenTrailers = map[string]struct{}{
+	"authorization":       {},
+	"cache-control":       {},
+	"connection":          {},
+	"content-encoding":    {},
+	"content-length":      {},
+	"content-range":       {},
+	"content-type":        {},
+	"expect":              {},
+	"host":                {},
+	"keep-alive":          {},
+	"max-forwards":        {},
+	"pragma":              {},
+	"proxy-authenticate":  {},
+	"proxy-authorization": {},
+	"range":               {},
+	"set-cookie":          {},
+	"te":                  {},
+	"trailer":             {},
+	"transfer-encoding":   {},
+	"upgrade":             {},
+	"www-authenticate":    {},
+}
+
+// AllowedTrailer reports whether name may be sent as a trailer.
+func AllowedTrailer(name string) bool {
+	_, forbidden := forbiddenTrailers[strings.ToLower(name)]
+	return !forbidden
+}
+
+// computedTrailer is a trailer whose value is derived from the body.
+type computedTrailer struct {
+	name  string
+	sink  io.Writer // receives every body chunk
+	value func() string
+}
+
+// ComputeTrailer announces a trailer whose value is known only once the body
+// has been written: every chunk passed to WriteChunkedBody is also written to
+// sink, and value is called by WriteTrailers. It must be called before
+// WriteHeaders, which adds name to the Trailer header.
+func (w *Writer) ComputeTrailer(name string, sink io.Writer, value func() string) error {
+	if w.writerState != writerStateStatusLine && w.writerState != writerStateHeaders {
+		return fmt.Errorf("cannot announce trailers in state %d", w.writerState)
+	}
+	if !AllowedTrailer(name) {
+		return fmt.Errorf("%s is not allowed as a trailer", name)
+	}
+	w.computedTrailers = append(w.computedTrailers, computedTrailer{name: name, sink: sink, value: value})
+	return nil
+}
+
+// HashTrailer announces a trailer holding the hex encoded hash of the body,
+// computed with h.
+func (w *Writer) HashTrailer(name string, h hash.Hash) error {
+	return w.ComputeTrailer(name, h, func() string { return hex.EncodeToString(h.Sum(nil)) })
+}
+
+// SHA256Trailer announces a trailer holding the hex encoded SHA-256 of the
+// body.
+func (w *Writer) SHA256Trailer(name string) error {
+	return w.HashTrailer(name, sha256.New())
+}
+
+// checkTrailer validates a trailer passed to WriteTrailers.
+func (w *Writer) checkTrailer(name string) error {
+	if !AllowedTrailer(name) {
+		return fmt.Errorf("%s is not allowed as a trailer", name)
+	}
+	if _, ok := w.announcedTrailers[strings.ToLower(name)]; !ok {
+		return fmt.Errorf("trailer %s was not announced in the Trailer header", name)
+	}
+	for _, t := range w.computedTrailers {
+		if strings.EqualFold(t.name, name) {
+			return fmt.Errorf("trailer %s is computed", name)
+		}
+	}
+	return nil
+}
+
+// announcedTrailers returns the names listed in the Trailer header.
+func announcedTrailers(h headers.Headers) map[string]struct{} {
+	names := map[string]struct{}{}
+	list, _ := h.Get("trailer")
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names[strings.ToLower(name)] = struct{}{}
+		}
+	}
+	return names
+}
+
+// withComputedTrailers returns a copy of h announcing the computed trailers
+// too.
+func withComputedTrailers(h headers.Headers, computed []computedTrailer) headers.Headers {
+	out := headers.NewHeaders()
+	for key, value := range h {
+		out.Override(key, value)
+	}
+	announced := announcedTrailers(h)
+	for _, t := range computed {
+		if _, ok := announced[strings.ToLower(t.name)]; !ok {
+			out.Set("Trailer", t.name)
+		}
+	}
+	return out
+}
@@ -3,7 +3,9 @@ package response
 import (
 	"fmt"
 	"io"
-	"strings"
+	"maps"
+	"net"
+	"strconv"
 
 	"github.com/DanilShapilov/httpfromtcp/internal/headers"
 )
@@ -15,18 +17,134 @@ const (
 	writerStateHeaders
 	writerStateBody
 	writerStateTrailers
+	writerStateDone     // trailers written, the message is complete
+	writerStateHijacked // the handler took over the connection
 )
 
 type Writer struct {
 	writer      io.Writer
 	writerState writerState //ensures that the user of my library calls WriteStatusLine, WriteHeaders, and WriteBody in the correct order. It just gives them a nice explicit error if they do stuff out of order.
+
+	// output not sent yet, up to size bytes
+	buf  []byte
+	size int
+	err  error // first write error, every later write fails with it
+
+	// bookkeeping for logging and metrics
+	statusCode   StatusCode
+	bytesWritten int
+
+	// trailer names announced in the Trailer header, lowercased
+	announcedTrailers map[string]struct{}
+	computedTrailers  []computedTrailer
+
+	headerHooks []func(headers.Headers) // see OnWriteHeaders
+
+	hijack HijackFunc // set by the server, see Hijack
 }
 
+// DefaultBufferSize is the write buffer the server gives each response
+// unless configured otherwise.
+const DefaultBufferSize = 4096
+
+// writevThreshold is the payload size from which handing the parts to one
+// writev beats copying them together.
+const writevThreshold = 4096
+
+// NewWriter returns an unbuffered writer: every call is sent right away,
+// framing included, in a single write.
 func NewWriter(w io.Writer) *Writer {
+	return NewWriterSize(w, 0)
+}
+
+// NewWriterSize returns a writer that keeps up to size bytes of output
+// before sending it, so that small writes go out together. Buffered output
+// is only sent once the buffer fills up or on Flush. A size of 0 or less
+// means no buffering.
+func NewWriterSize(w io.Writer, size int) *Writer {
 	return &Writer{
 		writerState: writerStateStatusLine,
 		writer:      w,
+		size:        max(size, 0),
+	}
+}
+
+// Flush sends the buffered output. Streaming handlers call it whenever the
+// client should see what was written so far.
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
 	}
+	if len(w.buf) == 0 {
+		return nil
+	}
+	_, err := w.writer.Write(w.buf)
+	w.buf = w.buf[:0]
+	w.err = err
+	return err
+}
+
+// write queues parts as one piece of output. What doesn't fit in the buffer
+// is sent along with the buffered output: small parts are copied together
+// into a single write, large ones go out with one writev.
+func (w *Writer) write(parts ...[]byte) error {
+	if w.err != nil {
+		return w.err
+	}
+	n := 0
+	for _, p := range parts {
+		n += len(p)
+	}
+	if len(w.buf)+n <= w.size {
+		w.buf = appendParts(w.buf, parts)
+		return nil
+	}
+	if n <= w.size {
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		w.buf = appendParts(w.buf, parts)
+		return nil
+	}
+	if len(w.buf)+n < writevThreshold {
+		w.buf = appendParts(w.buf, parts)
+		return w.Flush()
+	}
+
+	bufs := make(net.Buffers, 0, len(parts)+1)
+	if len(w.buf) > 0 {
+		bufs = append(bufs, w.buf)
+	}
+	for _, p := range parts {
+		if len(p) > 0 {
+			bufs = append(bufs, p)
+		}
+	}
+	_, err := writeBuffers(w.writer, bufs)
+	w.buf = w.buf[:0]
+	w.err = err
+	return err
+}
+
+func appendParts(buf []byte, parts [][]byte) []byte {
+	for _, p := range parts {
+		buf = append(buf, p...)
+	}
+	return buf
+}
+
+// buffersWriter is implemented by connection wrappers that can pass a
+// writev through to the connection they wrap; net.Buffers only uses writev
+// on the net package's own connections.
+type buffersWriter interface {
+	WriteBuffers(v *net.Buffers) (int64, error)
+}
+
+func writeBuffers(w io.Writer, v net.Buffers) (int64, error) {
+	if bw, ok := w.(buffersWriter); ok {
+		return bw.WriteBuffers(&v)
+	}
+	return v.WriteTo(w)
 }
 
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
@@ -34,8 +152,19 @@ func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
 		return fmt.Errorf("cannot write status line in state %d", w.writerState)
 	}
 	defer func() { w.writerState = writerStateHeaders }()
-	_, err := w.writer.Write(getStatusLine(statusCode))
-	return err
+	w.statusCode = statusCode
+	return w.write(getStatusLine(statusCode))
+}
+
+// StatusCode returns the status written so far, or 0 before WriteStatusLine.
+func (w *Writer) StatusCode() StatusCode {
+	return w.statusCode
+}
+
+// BytesWritten returns the number of body bytes written so far, not counting
+// the status line, headers or chunked encoding framing.
+func (w *Writer) BytesWritten() int {
+	return w.bytesWritten
 }
 func (w *Writer) WriteHeaders(headers headers.Headers) error {
 	if w.writerState != writerStateHeaders {
@@ -43,49 +172,105 @@ func (w *Writer) WriteHeaders(headers headers.Headers) error {
 	}
 	defer func() { w.writerState = writerStateBody }()
 
-	var b strings.Builder
-	for key, value := range headers {
-		fmt.Fprintf(&b, "%s: %s%s", key, value, crlf)
+	if len(w.headerHooks) > 0 {
+		h := make(map[string]string, len(headers))
+		maps.Copy(h, headers)
+		headers = h
+		for _, fn := range w.headerHooks {
+			fn(headers)
+		}
+	}
+	if len(w.computedTrailers) > 0 {
+		headers = withComputedTrailers(headers, w.computedTrailers)
 	}
-	b.WriteString(crlf)
-	_, err := io.WriteString(w.writer, b.String())
+	w.announcedTrailers = announcedTrailers(headers)
 
-	return err
+	return w.write(appendFields(nil, headers))
+}
+
+// OnWriteHeaders registers fn to run right before the headers are written,
+// with a copy of them it may change. Middleware uses it to add headers
+// that depend on what the handler did, like a session cookie. Hooks run in
+// the order they were registered.
+func (w *Writer) OnWriteHeaders(fn func(h headers.Headers)) {
+	w.headerHooks = append(w.headerHooks, fn)
+}
+
+// appendFields appends a header or trailer section, terminated by the
+// empty line.
+func appendFields(b []byte, h headers.Headers) []byte {
+	for key, value := range h.All() {
+		b = append(b, key...)
+		b = append(b, ": "...)
+		b = append(b, value...)
+		b = append(b, crlf...)
+	}
+	return append(b, crlf...)
 }
 
 func (w *Writer) WriteBody(p []byte) (int, error) {
 	if w.writerState != writerStateBody {
 		return 0, fmt.Errorf("cannot write body in state %d", w.writerState)
 	}
-	return w.writer.Write(p)
+	if err := w.write(p); err != nil {
+		return 0, err
+	}
+	w.bytesWritten += len(p)
+	return len(p), nil
 }
 
-func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+// Write is WriteBody, so a Writer can be handed to io.Copy and friends.
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.WriteBody(p)
+}
+
+// ReadFrom copies r to the body like WriteBody. When the connection knows
+// how to read from r itself, as a TCP connection does from a file or
+// another TCP connection, it gets r directly and the kernel moves the data
+// with sendfile or splice. Anything else, like a TLS connection, is copied
+// through the buffer.
+func (w *Writer) ReadFrom(r io.Reader) (int64, error) {
 	if w.writerState != writerStateBody {
 		return 0, fmt.Errorf("cannot write body in state %d", w.writerState)
 	}
+	if rf, ok := w.writer.(io.ReaderFrom); ok && w.err == nil {
+		if err := w.Flush(); err != nil {
+			return 0, err
+		}
+		n, err := rf.ReadFrom(r)
+		w.bytesWritten += int(n)
+		return n, err
+	}
+	return io.CopyBuffer(writerOnly{w}, r, make([]byte, copyBufferSize))
+}
 
-	chunkSize := len(p)
+// copyBufferSize is the buffer ReadFrom copies through when it can't hand
+// the reader to the connection.
+const copyBufferSize = 32 << 10
 
-	nTotal := 0
-	n, err := fmt.Fprintf(w.writer, "%x\r\n", chunkSize)
-	if err != nil {
-		return nTotal, err
+// writerOnly hides ReadFrom from io.CopyBuffer, which would call it again.
+type writerOnly struct{ io.Writer }
+
+func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+	if w.writerState != writerStateBody {
+		return 0, fmt.Errorf("cannot write body in state %d", w.writerState)
 	}
-	nTotal += n
 
-	n, err = w.writer.Write(p)
-	if err != nil {
-		return nTotal, err
+	// an empty chunk would end the body
+	if len(p) == 0 {
+		return 0, nil
 	}
-	nTotal += n
 
-	n, err = w.writer.Write([]byte("\r\n"))
-	if err != nil {
-		return nTotal, err
+	var sizeLine [18]byte
+	size := append(strconv.AppendInt(sizeLine[:0], int64(len(p)), 16), crlf...)
+	if err := w.write(size, p, []byte(crlf)); err != nil {
+		return 0, err
 	}
-	nTotal += n
-	return nTotal, nil
+	w.bytesWritten += len(p)
+	for _, t := range w.computedTrailers {
+		t.sink.Write(p)
+	}
+	return len(size) + len(p) + len(crlf), nil
 
 	// my initial solution
 	// size := []byte(strconv.FormatInt(int64(len(p)), 16))
@@ -97,24 +282,33 @@ func (w *Writer) WriteChunkedBodyDone() (int, error) {
 		return 0, fmt.Errorf("cannot write body in state %d", w.writerState)
 	}
 
-	n, err := w.writer.Write([]byte("0" + crlf))
-	if err != nil {
-		return n, err
+	if err := w.write([]byte("0" + crlf)); err != nil {
+		return 0, err
 	}
 	w.writerState = writerStateTrailers
-	return n, nil
+	return len("0" + crlf), nil
 }
 
+// WriteTrailers ends a chunked message with trailers, which must have been
+// announced in the Trailer header and be allowed in a trailer section.
+// Computed trailers are added to h. Nothing can be written afterwards.
 func (w *Writer) WriteTrailers(h headers.Headers) error {
 	if w.writerState != writerStateTrailers {
 		return fmt.Errorf("cannot write trailers in state %d", w.writerState)
 	}
-	defer func() { w.writerState = writerStateBody }()
-	var b strings.Builder
-	for key, value := range h {
-		fmt.Fprintf(&b, "%s: %s%s", key, value, crlf)
+	for key := range h {
+		if err := w.checkTrailer(key); err != nil {
+			return err
+		}
 	}
-	b.WriteString(crlf)
-	_, err := io.WriteString(w.writer, b.String())
-	return err
+	defer func() { w.writerState = writerStateDone }()
+	if len(w.computedTrailers) > 0 {
+		withComputed := make(headers.Headers, len(h)+len(w.computedTrailers))
+		maps.Copy(withComputed, h)
+		h = withComputed
+		for _, t := range w.computedTrailers {
+			h[t.name] = t.value()
+		}
+	}
+	return w.write(appendFields(nil, h))
 }
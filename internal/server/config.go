@@ -0,0 +1,150 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log"
+	"net"
+	"time"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/metrics"
+	"github.com/DanilShapilov/httpfromtcp/internal/request"
+)
+
+// Config describes how a Server listens and serves. The zero value listens
+// on an ephemeral TCP port on all interfaces with no timeouts or limits.
+type Config struct {
+	// Network is "tcp" (the default) or "unix".
+	Network string
+	// Addr is the address to bind, e.g. ":42069" or "127.0.0.1:0" for tcp,
+	// or a socket path for unix. Ignored when Listener is set.
+	Addr string
+	// Listener, when set, is served as is instead of binding Addr. The
+	// server takes ownership and closes it on Close. The server stops
+	// serving once Accept fails with an error other than a timeout or
+	// running out of file descriptors.
+	Listener net.Listener
+
+	// ReadTimeout bounds reading the whole request, headers and body.
+	ReadTimeout time.Duration
+	// WriteTimeout bounds writing the response, counted from the moment
+	// the request has been read.
+	WriteTimeout time.Duration
+	// HandlerTimeout sets a deadline on the request context.
+	HandlerTimeout time.Duration
+
+	// WriteBufferSize is how much response output is buffered before it is
+	// sent. Defaults to response.DefaultBufferSize; negative disables
+	// buffering. Streaming handlers call Writer.Flush to send early.
+	WriteBufferSize int
+
+	// MaxHeaderBytes caps the request line plus headers. Requests over the
+	// limit get a 431.
+	MaxHeaderBytes int
+	// MaxBodyBytes caps the request Content-Length. Requests over the limit
+	// get a 413.
+	MaxBodyBytes int
+
+	// MaxConns caps the number of open connections. Once reached, the
+	// server stops accepting until a connection closes, leaving new clients
+	// in the kernel backlog. Hijacked connections count until the hijacker
+	// closes them. Zero means no limit.
+	MaxConns int
+	// MaxInFlight caps the number of handlers running at once. Requests
+	// over the limit wait up to QueueTimeout for a slot and then get a 503
+	// with a Retry-After header. Zero means no limit.
+	MaxInFlight int
+	// QueueTimeout is how long a request waits for a handler slot. Zero
+	// rejects right away when all slots are busy.
+	QueueTimeout time.Duration
+	// RetryAfter is advertised on 503 responses. Defaults to one second.
+	RetryAfter time.Duration
+
+	// Metrics, when set, gets the server's connection, traffic, request and
+	// parse error metrics. Serve it with metrics.Handler.
+	Metrics *metrics.Registry
+	// Route maps a request to the route label of the request metrics. It
+	// must return a bounded set of labels. Defaults to "other" for all
+	// requests.
+	Route func(*request.Request) string
+
+	// ErrorLog receives accept and connection errors. Defaults to the
+	// standard logger.
+	ErrorLog *log.Logger
+
+	// TLSConfig, when set, makes the server speak HTTPS on the listener.
+	TLSConfig *tls.Config
+	// KeyPairs are certificate files to serve HTTPS with, on top of or
+	// instead of TLSConfig. The certificate is picked by SNI; when no pair
+	// matches, TLSConfig.Certificates are used, or the first pair without
+	// them.
+	KeyPairs []KeyPair
+	// CertReloadInterval makes the server poll KeyPairs for changes and
+	// reload them. Zero disables polling.
+	CertReloadInterval time.Duration
+	// ReloadCertsOnSIGHUP reloads KeyPairs whenever the process gets SIGHUP.
+	ReloadCertsOnSIGHUP bool
+
+	// ClientAuth turns on client certificate authentication, typically
+	// tls.RequireAndVerifyClientCert or tls.VerifyClientCertIfGiven.
+	ClientAuth tls.ClientAuthType
+	// ClientCAs are the CAs client certificates are verified against.
+	// ClientAuth and ClientCAs need TLSConfig or KeyPairs, ServeConfig
+	// fails without them.
+	ClientCAs *x509.CertPool
+}
+
+// tlsConfig returns the TLS configuration to wrap the listener with, or nil
+// for plain HTTP. Client authentication without TLS is an error rather than
+// a server that silently verifies nobody.
+func (c Config) tlsConfig(certs *certStore) (*tls.Config, error) {
+	if c.TLSConfig == nil && certs == nil {
+		if c.ClientAuth != tls.NoClientCert || c.ClientCAs != nil {
+			return nil, errors.New("server: ClientAuth and ClientCAs need TLSConfig or KeyPairs")
+		}
+		return nil, nil
+	}
+	var tlsCfg *tls.Config
+	if c.TLSConfig != nil {
+		tlsCfg = c.TLSConfig.Clone()
+	} else {
+		tlsCfg = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	if certs != nil {
+		tlsCfg.GetCertificate = certs.getCertificate
+	}
+	if c.ClientAuth != tls.NoClientCert {
+		tlsCfg.ClientAuth = c.ClientAuth
+	}
+	if c.ClientCAs != nil {
+		tlsCfg.ClientCAs = c.ClientCAs
+	}
+	return tlsCfg, nil
+}
+
+func (c Config) listen(tlsCfg *tls.Config) (net.Listener, error) {
+	l := c.Listener
+	if l == nil {
+		network := c.Network
+		if network == "" {
+			network = "tcp"
+		}
+		var err error
+		l, err = net.Listen(network, c.Addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if tlsCfg != nil {
+		l = tls.NewListener(l, tlsCfg)
+	}
+	return l, nil
+}
+
+func (c Config) errorLog() *log.Logger {
+	if c.ErrorLog != nil {
+		return c.ErrorLog
+	}
+	return log.Default()
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"github.com/DanilShapilov/httpfromtcp/internal/request"
+	"github.com/DanilShapilov/httpfromtcp/internal/response"
+)
+
+// AllowClientIdentities returns a Handler that only lets requests through to
+// h when the client authenticated with a certificate and one of its names
+// (common name or subject alternative name) is in allowed. Everything else
+// gets a 403.
+func AllowClientIdentities(h Handler, allowed ...string) Handler {
+	allowSet := make(map[string]struct{}, len(allowed))
+	for _, name := range allowed {
+		allowSet[name] = struct{}{}
+	}
+	return func(w *response.Writer, req *request.Request) {
+		if req.ClientIdentity != nil {
+			for _, name := range req.ClientIdentity.Names() {
+				if _, ok := allowSet[name]; ok {
+					h(w, req)
+					return
+				}
+			}
+		}
+		body := []byte("client identity not allowed")
+		w.WriteStatusLine(response.StatusCodeForbidden)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}
+}
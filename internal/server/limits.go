@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/response"
+)
+
+const (
+	// accept backoff bounds, the same net/http uses
+	minAcceptDelay = 5 * time.Millisecond
+	maxAcceptDelay = time.Second
+
+	defaultRetryAfter = time.Second
+)
+
+// semaphore is a counting semaphore. A nil semaphore never blocks.
+type semaphore chan struct{}
+
+func newSemaphore(n int) semaphore {
+	if n <= 0 {
+		return nil
+	}
+	return make(semaphore, n)
+}
+
+// acquire takes a slot, waiting until one frees up or ctx is done.
+func (s semaphore) acquire(ctx context.Context) bool {
+	if s == nil {
+		return true
+	}
+	select {
+	case s <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// tryAcquire takes a slot, waiting at most timeout for one.
+func (s semaphore) tryAcquire(ctx context.Context, timeout time.Duration) bool {
+	if s == nil {
+		return true
+	}
+	select {
+	case s <- struct{}{}:
+		return true
+	default:
+	}
+	if timeout <= 0 {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return s.acquire(ctx)
+}
+
+func (s semaphore) release() {
+	if s != nil {
+		<-s
+	}
+}
+
+// nextAcceptDelay doubles the previous backoff delay within bounds.
+func nextAcceptDelay(delay time.Duration) time.Duration {
+	if delay == 0 {
+		return minAcceptDelay
+	}
+	delay *= 2
+	if delay > maxAcceptDelay {
+		return maxAcceptDelay
+	}
+	return delay
+}
+
+// temporaryAcceptError reports whether Accept may succeed again after err,
+// such as when the process is out of file descriptors. Any other error, a
+// closed or broken listener, ends the accept loop.
+func temporaryAcceptError(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE) ||
+		errors.Is(err, syscall.ECONNABORTED)
+}
+
+// writeOverloaded tells the client to come back later.
+func (s *Server) writeOverloaded(w *response.Writer) {
+	retryAfter := s.cfg.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = defaultRetryAfter
+	}
+	body := []byte("server is busy, try again later")
+	h := response.GetDefaultHeaders(len(body))
+	// Retry-After takes whole seconds, round up so it is never 0
+	h.Override("Retry-After", strconv.Itoa(int((retryAfter+time.Second-1)/time.Second)))
+	w.WriteStatusLine(response.StatusCodeServiceUnavailable)
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+}
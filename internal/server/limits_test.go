@@ -0,0 +1,211 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/headers"
+	"github.com/DanilShapilov/httpfromtcp/internal/request"
+	"github.com/DanilShapilov/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingHandler answers 200 once release is closed and reports each call
+// on started.
+func blockingHandler(started chan<- struct{}, release <-chan struct{}) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		started <- struct{}{}
+		<-release
+		okHandler(w, req)
+	}
+}
+
+// sendRequest writes a request and returns a channel with the full response.
+func sendRequest(t *testing.T, addr string) <-chan string {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	res := make(chan string, 1)
+	go func() {
+		defer conn.Close()
+		b, _ := io.ReadAll(conn)
+		res <- string(b)
+	}()
+	return res
+}
+
+func TestMaxInFlight(t *testing.T) {
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	srv, err := ServeConfig(Config{
+		Addr:        "127.0.0.1:0",
+		MaxInFlight: 1,
+		RetryAfter:  1500 * time.Millisecond,
+	}, blockingHandler(started, release))
+	require.NoError(t, err)
+	defer srv.Close()
+
+	// Test: Requests over the limit are rejected with Retry-After
+	first := sendRequest(t, srv.Addr().String())
+	<-started
+	res := <-sendRequest(t, srv.Addr().String())
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 503 Service Unavailable\r\n"))
+	assert.Contains(t, res, "retry-after: 2\r\n")
+	close(release)
+	assert.True(t, strings.HasPrefix(<-first, "HTTP/1.1 200 OK\r\n"))
+}
+
+func TestMaxInFlightQueue(t *testing.T) {
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	srv, err := ServeConfig(Config{
+		Addr:         "127.0.0.1:0",
+		MaxInFlight:  1,
+		QueueTimeout: 5 * time.Second,
+	}, blockingHandler(started, release))
+	require.NoError(t, err)
+	defer srv.Close()
+
+	// Test: Queued requests run once a slot frees up
+	first := sendRequest(t, srv.Addr().String())
+	<-started
+	second := sendRequest(t, srv.Addr().String())
+	select {
+	case <-started:
+		t.Fatal("second handler started while the first one was running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	assert.True(t, strings.HasPrefix(<-first, "HTTP/1.1 200 OK\r\n"))
+	<-started
+	assert.True(t, strings.HasPrefix(<-second, "HTTP/1.1 200 OK\r\n"))
+}
+
+func TestMaxConns(t *testing.T) {
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	srv, err := ServeConfig(Config{
+		Addr:     "127.0.0.1:0",
+		MaxConns: 1,
+	}, blockingHandler(started, release))
+	require.NoError(t, err)
+	defer srv.Close()
+
+	// Test: Connections over the cap wait in the backlog
+	first := sendRequest(t, srv.Addr().String())
+	<-started
+	second := sendRequest(t, srv.Addr().String())
+	select {
+	case <-started:
+		t.Fatal("second connection served while at the cap")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	assert.True(t, strings.HasPrefix(<-first, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasPrefix(<-second, "HTTP/1.1 200 OK\r\n"))
+}
+
+func TestMaxConnsHijacked(t *testing.T) {
+	hijacked := make(chan net.Conn, 1)
+	srv, err := ServeConfig(Config{Addr: "127.0.0.1:0", MaxConns: 1}, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget != "/upgrade" {
+			okHandler(w, req)
+			return
+		}
+		w.WriteStatusLine(response.StatusCodeSwitchingProtocols)
+		w.WriteHeaders(headers.NewHeaders())
+		conn, _, err := w.Hijack()
+		if err != nil {
+			panic(err)
+		}
+		hijacked <- conn
+	})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	// Test: A hijacked connection keeps its slot after the handler returns
+	upgraded, err := net.Dial("tcp", srv.Addr().String())
+	require.NoError(t, err)
+	defer upgraded.Close()
+	_, err = upgraded.Write([]byte("GET /upgrade HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	conn := <-hijacked
+	second := sendRequest(t, srv.Addr().String())
+	select {
+	case <-second:
+		t.Fatal("second connection served while a hijacked one holds the slot")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Test: Closing the hijacked connection frees the slot, once
+	require.NoError(t, conn.Close())
+	conn.Close()
+	assert.True(t, strings.HasPrefix(<-second, "HTTP/1.1 200 OK\r\n"))
+}
+
+// failingListener fails Accept a number of times with err, then blocks.
+type failingListener struct {
+	net.Listener
+	err      error
+	failures int
+	accepts  []time.Time
+	done     chan struct{}
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	l.accepts = append(l.accepts, time.Now())
+	if len(l.accepts) <= l.failures {
+		return nil, l.err
+	}
+	close(l.done)
+	select {}
+}
+
+func TestAcceptBackoff(t *testing.T) {
+	// Test: Accept errors back off exponentially
+	emfile := &net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept", syscall.EMFILE)}
+	l := &failingListener{err: emfile, failures: 4, done: make(chan struct{})}
+	srv := &Server{listener: l, logger: log.New(io.Discard, "", 0)}
+	srv.ctx, srv.cancel = t.Context(), func() {}
+	go srv.listen()
+	<-l.done
+	require.Len(t, l.accepts, 5)
+	for i, want := range []time.Duration{5, 10, 20, 40} {
+		assert.GreaterOrEqual(t, l.accepts[i+1].Sub(l.accepts[i]), want*time.Millisecond)
+	}
+	assert.Equal(t, time.Second, nextAcceptDelay(800*time.Millisecond))
+}
+
+func TestAcceptPermanentError(t *testing.T) {
+	// Test: Errors Accept won't recover from end the accept loop
+	for _, err := range []error{
+		&net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept", syscall.EBADF)},
+		&net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept", syscall.EINVAL)},
+		errors.New("listener broke"),
+	} {
+		l := &failingListener{err: err, failures: 1, done: make(chan struct{})}
+		srv := &Server{listener: l, logger: log.New(io.Discard, "", 0)}
+		srv.ctx, srv.cancel = t.Context(), func() {}
+		returned := make(chan struct{})
+		go func() {
+			srv.listen()
+			close(returned)
+		}()
+		select {
+		case <-returned:
+		case <-time.After(time.Second):
+			t.Fatalf("listen still retrying after %v", err)
+		}
+		assert.Len(t, l.accepts, 1)
+	}
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/metrics"
+	"github.com/DanilShapilov/httpfromtcp/internal/request"
+	"github.com/DanilShapilov/httpfromtcp/internal/response"
+)
+
+// serverMetrics is the server's instrumentation. A nil *serverMetrics turns
+// every method into a no-op, so call sites don't need to check.
+type serverMetrics struct {
+	activeConns *metrics.Gauge
+	bytesIn     *metrics.Counter
+	bytesOut    *metrics.Counter
+	parseErrors *metrics.CounterVec
+	requests    *metrics.CounterVec
+	latency     *metrics.HistogramVec
+
+	route func(*request.Request) string
+}
+
+func newServerMetrics(reg *metrics.Registry, route func(*request.Request) string) *serverMetrics {
+	if reg == nil {
+		return nil
+	}
+	if route == nil {
+		route = defaultRoute
+	}
+	return &serverMetrics{
+		activeConns: reg.NewGauge("http_active_connections", "Number of open client connections.").With(),
+		bytesIn:     reg.NewCounter("http_received_bytes_total", "Bytes read from clients.").With(),
+		bytesOut:    reg.NewCounter("http_sent_bytes_total", "Bytes written to clients.").With(),
+		parseErrors: reg.NewCounter("http_parse_errors_total", "Requests that could not be parsed, by error type.", "type"),
+		requests:    reg.NewCounter("http_requests_total", "Handled requests by method, status and route.", "method", "status", "route"),
+		latency:     reg.NewHistogram("http_request_duration_seconds", "Time spent handling requests.", nil, "method", "route"),
+		route:       route,
+	}
+}
+
+// defaultRoute puts every request under one label: paths come from the
+// client, and a series per path would grow the registry without bound.
+// Set Config.Route to tell routes apart.
+func defaultRoute(*request.Request) string {
+	return "other"
+}
+
+// methodLabel keeps the method label bounded, any token is a valid method.
+func methodLabel(method string) string {
+	switch method {
+	case "GET", "HEAD", "POST", "PUT", "DELETE", "CONNECT", "OPTIONS", "TRACE", "PATCH":
+		return method
+	}
+	return "OTHER"
+}
+
+func (m *serverMetrics) connOpened() {
+	if m != nil {
+		m.activeConns.Inc()
+	}
+}
+
+func (m *serverMetrics) connClosed() {
+	if m != nil {
+		m.activeConns.Dec()
+	}
+}
+
+func (m *serverMetrics) parseError(errType string) {
+	if m != nil {
+		m.parseErrors.With(errType).Inc()
+	}
+}
+
+func (m *serverMetrics) observe(req *request.Request, w *response.Writer, d time.Duration) {
+	if m == nil {
+		return
+	}
+	route := m.route(req)
+	method := methodLabel(req.RequestLine.Method)
+	status := strconv.Itoa(int(w.StatusCode()))
+	m.requests.With(method, status, route).Inc()
+	m.latency.With(method, route).Observe(d.Seconds())
+}
+
+// countConn wraps conn so reads and writes are counted, or returns it as is
+// without metrics.
+func (m *serverMetrics) countConn(conn net.Conn) net.Conn {
+	if m == nil {
+		return conn
+	}
+	return &countingConn{Conn: conn, in: m.bytesIn, out: m.bytesOut}
+}
+
+type countingConn struct {
+	net.Conn
+	in, out *metrics.Counter
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.in.Add(float64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.out.Add(float64(n))
+	return n, err
+}
+
+// ReadFrom keeps the response writer's sendfile and splice going through
+// the wrapper to the connection.
+func (c *countingConn) ReadFrom(r io.Reader) (int64, error) {
+	n, err := io.Copy(c.Conn, r)
+	c.out.Add(float64(n))
+	return n, err
+}
+
+// WriteBuffers keeps the response writer's writev going through the
+// wrapper to the connection.
+func (c *countingConn) WriteBuffers(v *net.Buffers) (int64, error) {
+	n, err := v.WriteTo(c.Conn)
+	c.out.Add(float64(n))
+	return n, err
+}
+
+// parseErrorType classifies a RequestFromReader error for the
+// http_parse_errors_total type label.
+func parseErrorType(err error) string {
+	switch {
+	case errors.Is(err, request.ErrHeaderTooLarge):
+		return "header_too_large"
+	case errors.Is(err, request.ErrBodyTooLarge):
+		return "body_too_large"
+	case errors.Is(err, request.ErrRequestLine):
+		return "request_line"
+	case errors.Is(err, request.ErrHeaders):
+		return "headers"
+	case errors.Is(err, request.ErrBody):
+		return "body"
+	case errors.Is(err, request.ErrIncomplete):
+		return "incomplete"
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return "timeout"
+	}
+	return "io"
+}
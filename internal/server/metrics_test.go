@@ -0,0 +1,50 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerMetrics(t *testing.T) {
+	reg := metrics.NewRegistry()
+	srv, err := ServeConfig(Config{Addr: "127.0.0.1:0", Metrics: reg}, okHandler)
+	require.NoError(t, err)
+	defer srv.Close()
+	addr := srv.Addr().String()
+
+	roundTrip(t, "tcp", addr, "GET /coffee?size=large HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	roundTrip(t, "tcp", addr, "GET /coffee HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	roundTrip(t, "tcp", addr, "GeT /coffee HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	roundTrip(t, "tcp", addr, "GET /coffee HTTP/1.1\r\nHost localhost\r\n\r\n")
+	roundTrip(t, "tcp", addr, "BREW /tea HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+	var b strings.Builder
+	require.NoError(t, reg.WriteText(&b))
+	text := b.String()
+
+	// Test: Requests by method, status and route, client paths and
+	// non-standard methods don't make new series
+	assert.Contains(t, text, `http_requests_total{method="GET",status="200",route="other"} 2`+"\n")
+	assert.Contains(t, text, `http_request_duration_seconds_count{method="GET",route="other"} 2`+"\n")
+	assert.Contains(t, text, `http_requests_total{method="OTHER",status="200",route="other"} 1`+"\n")
+	assert.NotContains(t, text, "/coffee")
+	assert.NotContains(t, text, "BREW")
+
+	// Test: Parse errors by type
+	assert.Contains(t, text, `http_parse_errors_total{type="request_line"} 1`+"\n")
+	assert.Contains(t, text, `http_parse_errors_total{type="headers"} 1`+"\n")
+
+	// Test: Traffic is counted and connections are closed
+	assert.Eventually(t, func() bool {
+		b.Reset()
+		require.NoError(t, reg.WriteText(&b))
+		return strings.Contains(b.String(), "http_active_connections 0\n")
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.NotContains(t, text, "http_received_bytes_total 0\n")
+	assert.NotContains(t, text, "http_sent_bytes_total 0\n")
+}
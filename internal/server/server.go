@@ -1,15 +1,24 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/DanilShapilov/httpfromtcp/internal/request"
 	"github.com/DanilShapilov/httpfromtcp/internal/response"
 )
 
+// lingerTimeout bounds how long lingerClose waits for the client to finish
+const lingerTimeout = 500 * time.Millisecond
+
 type Handler func(w *response.Writer, req *request.Request)
 
 // Server is an HTTP 1.1 server
@@ -17,10 +26,22 @@ type Server struct {
 	listener net.Listener
 	closed   atomic.Bool
 	handler  Handler
+	cfg      Config
+	logger   *log.Logger
+	certs    *certStore // nil unless cfg.KeyPairs is set
+
+	conns    semaphore // open connections, nil without cfg.MaxConns
+	inFlight semaphore // running handlers, nil without cfg.MaxInFlight
+	metrics  *serverMetrics
+
+	// ctx is the parent of every request context, cancelled on Close
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 func (s *Server) Close() error {
 	s.closed.Store(true)
+	s.cancel()
 	if s.listener != nil {
 		return s.listener.Close()
 	}
@@ -28,44 +49,276 @@ func (s *Server) Close() error {
 }
 
 func (s *Server) listen() {
+	var delay time.Duration
 	for {
+		if !s.conns.acquire(s.ctx) {
+			return
+		}
 		// Wait on connection
 		conn, err := s.listener.Accept()
 		if err != nil {
+			s.conns.release()
 			if s.closed.Load() {
 				return
 			}
-			log.Printf("Error accepting connection: %v", err)
+			if !temporaryAcceptError(err) {
+				s.logger.Printf("Error accepting connection: %v; giving up", err)
+				return
+			}
+			// most likely out of file descriptors, give the running
+			// connections a chance to finish instead of spinning
+			delay = nextAcceptDelay(delay)
+			s.logger.Printf("Error accepting connection: %v; retrying in %v", err, delay)
+			select {
+			case <-time.After(delay):
+			case <-s.ctx.Done():
+				return
+			}
 			continue
 		}
+		delay = 0
 		go s.handle(conn)
 	}
 }
 
 func (s *Server) handle(conn net.Conn) {
-	defer conn.Close()
-	w := response.NewWriter(conn)
-	req, err := request.RequestFromReader(conn)
+	// a hijacked connection keeps its slot and gauge until it is closed
+	hijacked := false
+	defer func() {
+		if !hijacked {
+			conn.Close()
+			s.metrics.connClosed()
+			s.conns.release()
+		}
+	}()
+	s.metrics.connOpened()
+	rw := s.metrics.countConn(conn)
+	bufSize := s.cfg.WriteBufferSize
+	if bufSize == 0 {
+		bufSize = response.DefaultBufferSize
+	}
+	w := response.NewWriterSize(rw, bufSize)
+	// runs before the connection is closed, and is a no-op once hijacked
+	defer w.Flush()
+	if s.cfg.ReadTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(s.cfg.ReadTimeout))
+	}
+	tlsConn, isTLS := conn.(*tls.Conn)
+	if isTLS {
+		if err := tlsConn.HandshakeContext(s.ctx); err != nil {
+			s.logger.Printf("TLS handshake error from %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+	req, err := request.RequestFromReaderWithLimits(rw, request.Limits{
+		MaxHeaderBytes: s.cfg.MaxHeaderBytes,
+		MaxBodyBytes:   s.cfg.MaxBodyBytes,
+	})
 	if err != nil {
-		w.WriteStatusLine(response.StatusCodeBadRequest)
+		s.metrics.parseError(parseErrorType(err))
+		statusCode := response.StatusCodeBadRequest
+		switch {
+		case errors.Is(err, request.ErrHeaderTooLarge):
+			statusCode = response.StatusCodeRequestHeaderFieldsTooLarge
+		case errors.Is(err, request.ErrBodyTooLarge):
+			statusCode = response.StatusCodeContentTooLarge
+		}
+		w.WriteStatusLine(statusCode)
 		body := []byte(fmt.Sprintf("Error parsing request: %v", err))
 		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
 		w.WriteBody(body)
+		w.Flush()
+		lingerClose(conn)
+		return
+	}
+	req.RemoteAddr = conn.RemoteAddr().String()
+	if isTLS {
+		state := tlsConn.ConnectionState()
+		req.TLS = &state
+		req.ClientIdentity = request.IdentityFromTLS(&state)
+	}
+	// the watcher below must block until the client goes away
+	conn.SetReadDeadline(time.Time{})
+	if s.cfg.WriteTimeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(s.cfg.WriteTimeout))
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	if s.cfg.HandlerTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.cfg.HandlerTimeout)
+		defer cancel()
+	}
+	watcher := watchDisconnect(conn, cancel)
+	w.EnableHijack(func() (net.Conn, []byte, error) {
+		read, err := watcher.stop()
+		if err != nil {
+			return nil, nil, err
+		}
+		conn.SetDeadline(time.Time{})
+		hijacked = true
+		hc := &hijackedConn{Conn: rw, release: func() {
+			s.metrics.connClosed()
+			s.conns.release()
+		}}
+		return hc, append(req.Buffered(), read...), nil
+	})
+
+	start := time.Now()
+	defer func() { s.metrics.observe(req, w, time.Since(start)) }()
+
+	if !s.inFlight.tryAcquire(ctx, s.cfg.QueueTimeout) {
+		s.writeOverloaded(w)
 		return
 	}
-	s.handler(w, req)
+	defer s.inFlight.release()
+
+	s.handler(w, req.WithContext(ctx))
+}
+
+// hijackedConn counts against MaxConns and the active connections gauge
+// until the hijacker closes it.
+type hijackedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *hijackedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
+
+// disconnectWatcher cancels the request context once the client closes its
+// side of the connection. The request has been read in full at this point
+// and the connection is never reused, so extra bytes are only kept in case
+// the handler hijacks the connection. The read fails as soon as handle
+// closes conn, so the goroutine never outlives the connection.
+type disconnectWatcher struct {
+	conn    net.Conn
+	cancel  context.CancelFunc
+	stopped atomic.Bool
+	done    chan struct{}
+
+	// read and overflow belong to the watcher goroutine until done is
+	// closed
+	read     []byte
+	overflow bool
 }
 
+// maxWatcherBuffer bounds what a client can make us keep for a hijack.
+const maxWatcherBuffer = 64 << 10
+
+func watchDisconnect(conn net.Conn, cancel context.CancelFunc) *disconnectWatcher {
+	dw := &disconnectWatcher{conn: conn, cancel: cancel, done: make(chan struct{})}
+	go dw.run()
+	return dw
+}
+
+func (dw *disconnectWatcher) run() {
+	defer close(dw.done)
+	var b [512]byte
+	for {
+		n, err := dw.conn.Read(b[:])
+		if len(dw.read)+n > maxWatcherBuffer {
+			dw.overflow = true
+		} else {
+			dw.read = append(dw.read, b[:n]...)
+		}
+		if err != nil {
+			if !dw.stopped.Load() {
+				dw.cancel()
+			}
+			return
+		}
+	}
+}
+
+// stop ends the watch without cancelling, so the connection can be read by
+// someone else, and returns what the watcher read. It interrupts the
+// pending read with a deadline in the past.
+func (dw *disconnectWatcher) stop() ([]byte, error) {
+	dw.stopped.Store(true)
+	dw.conn.SetReadDeadline(time.Unix(1, 0))
+	<-dw.done
+	dw.conn.SetReadDeadline(time.Time{})
+	if dw.overflow {
+		return nil, errors.New("server: client sent too much data to hijack the connection")
+	}
+	return dw.read, nil
+}
+
+// lingerClose signals the end of the response and discards whatever the
+// client is still sending. Closing a socket with unread data makes the
+// kernel send a RST, which can destroy the error response before the
+// client gets to read it.
+func lingerClose(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+	conn.SetReadDeadline(time.Now().Add(lingerTimeout))
+	io.Copy(io.Discard, conn)
+}
+
+// TimeoutHandler returns a Handler that runs h with a request context that
+// is cancelled after d. It is up to h to watch req.Context() and stop work.
+func TimeoutHandler(h Handler, d time.Duration) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		ctx, cancel := context.WithTimeout(req.Context(), d)
+		defer cancel()
+		h(w, req.WithContext(ctx))
+	}
+}
+
+// Addr returns the address the server is actually bound to, which is how
+// callers learn the port picked for ":0".
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Serve listens on the given TCP port on all interfaces.
 func Serve(port int, handler Handler) (*Server, error) {
-	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	return ServeConfig(Config{Addr: fmt.Sprintf(":%d", port)}, handler)
+}
+
+// ServeConfig starts a server described by cfg and returns once it is
+// accepting connections.
+func ServeConfig(cfg Config, handler Handler) (*Server, error) {
+	var certs *certStore
+	if len(cfg.KeyPairs) > 0 {
+		var err error
+		certs, err = newCertStore(cfg.KeyPairs)
+		if err != nil {
+			return nil, err
+		}
+		certs.deferUnmatched = cfg.TLSConfig != nil && len(cfg.TLSConfig.Certificates) > 0
+	}
+	tlsCfg, err := cfg.tlsConfig(certs)
 	if err != nil {
 		return nil, err
 	}
+	l, err := cfg.listen(tlsCfg)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
 	srv := &Server{
 		listener: l,
 		handler:  handler,
+		cfg:      cfg,
+		logger:   cfg.errorLog(),
+		certs:    certs,
+		conns:    newSemaphore(cfg.MaxConns),
+		inFlight: newSemaphore(cfg.MaxInFlight),
+		metrics:  newServerMetrics(cfg.Metrics, cfg.Route),
+		ctx:      ctx,
+		cancel:   cancel,
 	}
 
+	if certs != nil {
+		go srv.watchCertificates()
+	}
 	go srv.listen()
 	return srv, nil
 }
@@ -1 +1,203 @@
 package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/headers"
+	"github.com/DanilShapilov/httpfromtcp/internal/request"
+	"github.com/DanilShapilov/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestContextCancelled(t *testing.T) {
+	cancelled := make(chan error, 1)
+	srv, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		<-req.Context().Done()
+		cancelled <- req.Context().Err()
+	})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	// Test: Client disconnect cancels the request context
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	conn.Close()
+	select {
+	case err := <-cancelled:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(2 * time.Second):
+		t.Fatal("context not cancelled on client disconnect")
+	}
+
+	// Test: Server shutdown cancels the request context
+	conn, err = net.Dial("tcp", srv.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+	srv.Close()
+	select {
+	case err := <-cancelled:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(2 * time.Second):
+		t.Fatal("context not cancelled on server shutdown")
+	}
+}
+
+func TestTimeoutHandler(t *testing.T) {
+	// Test: Handler context expires after the timeout
+	var ctxErr error
+	h := TimeoutHandler(func(w *response.Writer, req *request.Request) {
+		<-req.Context().Done()
+		ctxErr = req.Context().Err()
+	}, 10*time.Millisecond)
+	h(nil, &request.Request{})
+	assert.ErrorIs(t, ctxErr, context.DeadlineExceeded)
+}
+
+// roundTrip sends a raw request and returns everything the server wrote
+// before closing the connection.
+func roundTrip(t *testing.T, network, addr, raw string) string {
+	t.Helper()
+	conn, err := net.Dial(network, addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte(raw))
+	require.NoError(t, err)
+	res, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return string(res)
+}
+
+func okHandler(w *response.Writer, _ *request.Request) {
+	body := []byte("ok")
+	w.WriteStatusLine(response.StatusCodeSuccess)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func TestServeConfig(t *testing.T) {
+	// Test: Two instances on ephemeral ports report distinct addresses
+	srv1, err := ServeConfig(Config{Addr: "127.0.0.1:0"}, okHandler)
+	require.NoError(t, err)
+	defer srv1.Close()
+	srv2, err := ServeConfig(Config{Addr: "127.0.0.1:0"}, okHandler)
+	require.NoError(t, err)
+	defer srv2.Close()
+	assert.NotEqual(t, srv1.Addr().String(), srv2.Addr().String())
+	res := roundTrip(t, "tcp", srv1.Addr().String(), "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(res, "\r\n\r\nok"))
+
+	// Test: Externally supplied listener
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv3, err := ServeConfig(Config{Listener: l}, okHandler)
+	require.NoError(t, err)
+	defer srv3.Close()
+	assert.Equal(t, l.Addr().String(), srv3.Addr().String())
+	res = roundTrip(t, "tcp", srv3.Addr().String(), "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 200 OK\r\n"))
+
+	// Test: Unix domain socket
+	sock := filepath.Join(t.TempDir(), "server.sock")
+	srv4, err := ServeConfig(Config{Network: "unix", Addr: sock}, okHandler)
+	require.NoError(t, err)
+	defer srv4.Close()
+	res = roundTrip(t, "unix", sock, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 200 OK\r\n"))
+}
+
+func TestServeConfigLimits(t *testing.T) {
+	srv, err := ServeConfig(Config{
+		Addr:           "127.0.0.1:0",
+		MaxHeaderBytes: 64,
+		MaxBodyBytes:   4,
+		ReadTimeout:    100 * time.Millisecond,
+	}, okHandler)
+	require.NoError(t, err)
+	defer srv.Close()
+	addr := srv.Addr().String()
+
+	// Test: Headers over the limit
+	res := roundTrip(t, "tcp", addr, "GET / HTTP/1.1\r\nHost: localhost\r\nX-Padding: "+strings.Repeat("a", 64)+"\r\n\r\n")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 431 Request Header Fields Too Large\r\n"))
+
+	// Test: Body over the limit
+	res = roundTrip(t, "tcp", addr, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 413 Content Too Large\r\n"))
+
+	// Test: Body within the limit
+	res = roundTrip(t, "tcp", addr, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 4\r\n\r\nhell")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 200 OK\r\n"))
+
+	// Test: Read timeout on an incomplete request
+	res = roundTrip(t, "tcp", addr, "GET / HTTP/1.1\r\nHost: localhost\r\n")
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 400 Bad Request\r\n"))
+}
+
+func TestHijack(t *testing.T) {
+	type result struct {
+		buffered []byte
+		err      error
+	}
+	results := make(chan result, 1)
+	srv, err := ServeConfig(Config{Addr: "127.0.0.1:0"}, func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusCodeSwitchingProtocols)
+		w.WriteHeaders(headers.NewHeaders())
+		conn, buffered, err := w.Hijack()
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		_, _, err = w.Hijack()
+		results <- result{buffered: buffered, err: err}
+		// the server must leave the connection open once the handler returns
+		go func() {
+			defer conn.Close()
+			io.Copy(conn, io.MultiReader(bytes.NewReader(buffered), conn))
+		}()
+	})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	// Test: Bytes sent along with the request are handed to the hijacker
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\nearly"))
+	require.NoError(t, err)
+	res := <-results
+	require.NotErrorIs(t, res.err, response.ErrNotHijackable)
+	assert.ErrorIs(t, res.err, response.ErrHijacked)
+	assert.Equal(t, "early", string(res.buffered))
+
+	// Test: The hijacked connection stays open after the handler returns
+	_, err = conn.Write([]byte(" late"))
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var got []byte
+	for !bytes.HasSuffix(got, []byte("early late")) {
+		b := make([]byte, 64)
+		n, err := conn.Read(b)
+		require.NoError(t, err)
+		got = append(got, b[:n]...)
+	}
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n\r\nearly late", string(got))
+
+	// Test: Writers the server didn't create can't be hijacked
+	_, _, err = response.NewWriter(io.Discard).Hijack()
+	assert.ErrorIs(t, err, response.ErrNotHijackable)
+}
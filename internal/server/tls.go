@@ -0,0 +1,171 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// KeyPair names a PEM encoded certificate chain and its private key.
+type KeyPair struct {
+	CertFile string
+	KeyFile  string
+}
+
+// certStore holds the certificates loaded from a set of key pairs and picks
+// one per handshake based on SNI. Reloading swaps the whole set at once, so
+// in-flight handshakes keep the certificate they started with.
+type certStore struct {
+	pairs []KeyPair
+	// deferUnmatched leaves handshakes no pair fits to the certificates of
+	// Config.TLSConfig
+	deferUnmatched bool
+
+	mu       sync.RWMutex
+	certs    []*tls.Certificate
+	modTimes []time.Time
+}
+
+func newCertStore(pairs []KeyPair) (*certStore, error) {
+	cs := &certStore{pairs: pairs}
+	if err := cs.reload(); err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+// reload reads every key pair from disk. On error the previous certificates
+// stay in place.
+func (cs *certStore) reload() error {
+	certs := make([]*tls.Certificate, 0, len(cs.pairs))
+	modTimes := make([]time.Time, 0, len(cs.pairs))
+	for _, p := range cs.pairs {
+		modTime, err := pairModTime(p)
+		if err != nil {
+			return err
+		}
+		cert, err := tls.LoadX509KeyPair(p.CertFile, p.KeyFile)
+		if err != nil {
+			return fmt.Errorf("loading %s: %w", p.CertFile, err)
+		}
+		cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("parsing %s: %w", p.CertFile, err)
+		}
+		certs = append(certs, &cert)
+		modTimes = append(modTimes, modTime)
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.certs = certs
+	cs.modTimes = modTimes
+	return nil
+}
+
+// changed reports whether any of the files was modified since the last load.
+func (cs *certStore) changed() bool {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	for i, p := range cs.pairs {
+		modTime, err := pairModTime(p)
+		if err != nil {
+			// half written files show up as errors, try again next time
+			continue
+		}
+		if !modTime.Equal(cs.modTimes[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// getCertificate is used as tls.Config.GetCertificate. It returns the first
+// certificate that fits the client hello. Otherwise it returns nil so
+// crypto/tls picks from TLSConfig.Certificates, or without those the first
+// pair so clients without SNI still get an answer.
+func (cs *certStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	if len(cs.certs) == 0 {
+		return nil, errors.New("no certificates loaded")
+	}
+	for _, cert := range cs.certs {
+		if hello.SupportsCertificate(cert) == nil {
+			return cert, nil
+		}
+	}
+	if cs.deferUnmatched {
+		return nil, nil
+	}
+	return cs.certs[0], nil
+}
+
+// pairModTime returns the latest modification time of the pair's files.
+func pairModTime(p KeyPair) (time.Time, error) {
+	certInfo, err := os.Stat(p.CertFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	keyInfo, err := os.Stat(p.KeyFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if keyInfo.ModTime().After(certInfo.ModTime()) {
+		return keyInfo.ModTime(), nil
+	}
+	return certInfo.ModTime(), nil
+}
+
+// ReloadCertificates re-reads the configured key pairs from disk. New
+// handshakes use the new certificates, established connections are not
+// affected. It is a no-op for servers without KeyPairs.
+func (s *Server) ReloadCertificates() error {
+	if s.certs == nil {
+		return nil
+	}
+	return s.certs.reload()
+}
+
+// watchCertificates reloads certificates on SIGHUP and when the files change,
+// depending on the config, until the server is closed.
+func (s *Server) watchCertificates() {
+	var tick <-chan time.Time
+	if s.cfg.CertReloadInterval > 0 {
+		ticker := time.NewTicker(s.cfg.CertReloadInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	var hup chan os.Signal
+	if s.cfg.ReloadCertsOnSIGHUP {
+		hup = make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+	}
+	if tick == nil && hup == nil {
+		return
+	}
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-tick:
+			if !s.certs.changed() {
+				continue
+			}
+		case <-hup:
+		}
+		if err := s.certs.reload(); err != nil {
+			s.logger.Printf("Error reloading certificates: %v", err)
+			continue
+		}
+		s.logger.Printf("Reloaded TLS certificates")
+	}
+}
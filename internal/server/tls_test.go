@@ -0,0 +1,317 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/request"
+	"github.com/DanilShapilov/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeSelfSigned generates a self-signed certificate for dnsName, writes
+// the PEM files into dir and returns them as a KeyPair.
+func writeSelfSigned(t *testing.T, dir, dnsName string) (KeyPair, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: dnsName},
+		DNSNames:     []string{dnsName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	pair := KeyPair{
+		CertFile: filepath.Join(dir, dnsName+".crt"),
+		KeyFile:  filepath.Join(dir, dnsName+".key"),
+	}
+	err = os.WriteFile(pair.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	require.NoError(t, err)
+	err = os.WriteFile(pair.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	require.NoError(t, err)
+	return pair, cert
+}
+
+// tlsRoundTrip sends a raw request over TLS and returns the response along
+// with the certificate the server presented.
+func tlsRoundTrip(t *testing.T, addr string, cfg *tls.Config, raw string) (string, *x509.Certificate) {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, cfg)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte(raw))
+	require.NoError(t, err)
+	res, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return string(res), conn.ConnectionState().PeerCertificates[0]
+}
+
+func tlsVersionHandler(w *response.Writer, req *request.Request) {
+	body := []byte("plain")
+	if req.TLS != nil {
+		body = []byte(tls.VersionName(req.TLS.Version))
+	}
+	w.WriteStatusLine(response.StatusCodeSuccess)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func TestServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	pairA, certA := writeSelfSigned(t, dir, "a.test")
+	pairB, certB := writeSelfSigned(t, dir, "b.test")
+	roots := x509.NewCertPool()
+	roots.AddCert(certA)
+	roots.AddCert(certB)
+
+	srv, err := ServeConfig(Config{
+		Addr:     "127.0.0.1:0",
+		KeyPairs: []KeyPair{pairA, pairB},
+	}, tlsVersionHandler)
+	require.NoError(t, err)
+	defer srv.Close()
+	addr := srv.Addr().String()
+	raw := "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"
+
+	// Test: TLS state is exposed on the request
+	res, peer := tlsRoundTrip(t, addr, &tls.Config{ServerName: "a.test", RootCAs: roots, MaxVersion: tls.VersionTLS12}, raw)
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(res, "TLS 1.2"))
+	assert.Equal(t, "a.test", peer.Subject.CommonName)
+
+	// Test: SNI picks the matching certificate
+	res, peer = tlsRoundTrip(t, addr, &tls.Config{ServerName: "b.test", RootCAs: roots}, raw)
+	assert.True(t, strings.HasSuffix(res, "TLS 1.3"))
+	assert.Equal(t, "b.test", peer.Subject.CommonName)
+
+	// Test: Unknown SNI falls back to the first certificate
+	_, peer = tlsRoundTrip(t, addr, &tls.Config{ServerName: "c.test", InsecureSkipVerify: true}, raw)
+	assert.Equal(t, "a.test", peer.Subject.CommonName)
+
+	// Test: Reload picks up replaced files
+	newPairA, newCertA := writeSelfSigned(t, t.TempDir(), "a.test")
+	require.NoError(t, os.Rename(newPairA.CertFile, pairA.CertFile))
+	require.NoError(t, os.Rename(newPairA.KeyFile, pairA.KeyFile))
+	require.NoError(t, srv.ReloadCertificates())
+	_, peer = tlsRoundTrip(t, addr, &tls.Config{ServerName: "a.test", InsecureSkipVerify: true}, raw)
+	assert.Equal(t, newCertA.SerialNumber, peer.SerialNumber)
+
+	// Test: Failed reload keeps serving the previous certificates
+	require.NoError(t, os.WriteFile(pairA.KeyFile, []byte("garbage"), 0o600))
+	require.Error(t, srv.ReloadCertificates())
+	_, peer = tlsRoundTrip(t, addr, &tls.Config{ServerName: "a.test", InsecureSkipVerify: true}, raw)
+	assert.Equal(t, newCertA.SerialNumber, peer.SerialNumber)
+}
+
+func TestKeyPairsWithTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	pairA, _ := writeSelfSigned(t, dir, "a.test")
+	pairC, _ := writeSelfSigned(t, dir, "c.test")
+	certC, err := tls.LoadX509KeyPair(pairC.CertFile, pairC.KeyFile)
+	require.NoError(t, err)
+
+	srv, err := ServeConfig(Config{
+		Addr:      "127.0.0.1:0",
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{certC}},
+		KeyPairs:  []KeyPair{pairA},
+	}, tlsVersionHandler)
+	require.NoError(t, err)
+	defer srv.Close()
+	addr := srv.Addr().String()
+	raw := "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"
+
+	// Test: SNI matching a key pair gets the pair
+	_, peer := tlsRoundTrip(t, addr, &tls.Config{ServerName: "a.test", InsecureSkipVerify: true}, raw)
+	assert.Equal(t, "a.test", peer.Subject.CommonName)
+
+	// Test: Other names get the certificates of TLSConfig
+	for _, name := range []string{"c.test", "d.test"} {
+		_, peer = tlsRoundTrip(t, addr, &tls.Config{ServerName: name, InsecureSkipVerify: true}, raw)
+		assert.Equal(t, "c.test", peer.Subject.CommonName, name)
+	}
+}
+
+func TestCertificatePolling(t *testing.T) {
+	dir := t.TempDir()
+	pair, _ := writeSelfSigned(t, dir, "a.test")
+	srv, err := ServeConfig(Config{
+		Addr:               "127.0.0.1:0",
+		KeyPairs:           []KeyPair{pair},
+		CertReloadInterval: 10 * time.Millisecond,
+		ErrorLog:           log.New(io.Discard, "", 0),
+	}, tlsVersionHandler)
+	require.NoError(t, err)
+	defer srv.Close()
+
+	// Test: Changed files are reloaded without an explicit call
+	newPair, newCert := writeSelfSigned(t, t.TempDir(), "a.test")
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Rename(newPair.CertFile, pair.CertFile))
+	require.NoError(t, os.Rename(newPair.KeyFile, pair.KeyFile))
+	require.NoError(t, os.Chtimes(pair.CertFile, future, future))
+	assert.Eventually(t, func() bool {
+		_, peer := tlsRoundTrip(t, srv.Addr().String(), &tls.Config{ServerName: "a.test", InsecureSkipVerify: true}, "GET / HTTP/1.1\r\n\r\n")
+		return peer.SerialNumber.Cmp(newCert.SerialNumber) == 0
+	}, 2*time.Second, 20*time.Millisecond)
+}
+
+// testCA is a throwaway certificate authority for client certificates.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, key: key}
+}
+
+// issueClient returns a client certificate for commonName signed by the CA.
+func (ca *testCA) issueClient(t *testing.T, commonName string, dnsNames ...string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	pair, serverCert := writeSelfSigned(t, dir, "a.test")
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCert)
+	ca := newTestCA(t)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+
+	identityHandler := func(w *response.Writer, req *request.Request) {
+		body := []byte("anonymous")
+		if req.ClientIdentity != nil {
+			body = []byte(strings.Join(req.ClientIdentity.Names(), ","))
+		}
+		w.WriteStatusLine(response.StatusCodeSuccess)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}
+	raw := "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"
+
+	// Test: Client auth without TLS refuses to start instead of serving
+	// plain HTTP
+	for _, cfg := range []Config{
+		{Addr: "127.0.0.1:0", ClientAuth: tls.RequireAndVerifyClientCert},
+		{Addr: "127.0.0.1:0", ClientCAs: clientCAs},
+	} {
+		_, err := ServeConfig(cfg, identityHandler)
+		require.Error(t, err)
+	}
+
+	// Test: Optional client certs expose the verified identity
+	optional, err := ServeConfig(Config{
+		Addr:       "127.0.0.1:0",
+		KeyPairs:   []KeyPair{pair},
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		ClientCAs:  clientCAs,
+	}, identityHandler)
+	require.NoError(t, err)
+	defer optional.Close()
+	clientCert := ca.issueClient(t, "billing", "billing.internal")
+	res, _ := tlsRoundTrip(t, optional.Addr().String(), &tls.Config{
+		ServerName:   "a.test",
+		RootCAs:      roots,
+		Certificates: []tls.Certificate{clientCert},
+	}, raw)
+	assert.True(t, strings.HasSuffix(res, "\r\n\r\nbilling,billing.internal"))
+
+	// Test: Optional client certs let anonymous clients through
+	res, _ = tlsRoundTrip(t, optional.Addr().String(), &tls.Config{ServerName: "a.test", RootCAs: roots}, raw)
+	assert.True(t, strings.HasSuffix(res, "\r\n\r\nanonymous"))
+
+	// Test: Required client certs reject anonymous clients during handshake
+	required, err := ServeConfig(Config{
+		Addr:       "127.0.0.1:0",
+		KeyPairs:   []KeyPair{pair},
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+		ErrorLog:   log.New(io.Discard, "", 0),
+	}, AllowClientIdentities(identityHandler, "billing.internal"))
+	require.NoError(t, err)
+	defer required.Close()
+	conn, err := tls.Dial("tcp", required.Addr().String(), &tls.Config{ServerName: "a.test", RootCAs: roots})
+	if err == nil {
+		_, err = conn.Write([]byte(raw))
+		if err == nil {
+			_, err = io.ReadAll(conn)
+		}
+		conn.Close()
+	}
+	require.Error(t, err)
+
+	// Test: Allowlisted identity passes the middleware
+	res, _ = tlsRoundTrip(t, required.Addr().String(), &tls.Config{
+		ServerName:   "a.test",
+		RootCAs:      roots,
+		Certificates: []tls.Certificate{clientCert},
+	}, raw)
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 200 OK\r\n"))
+
+	// Test: Identity missing from the allowlist gets a 403
+	res, _ = tlsRoundTrip(t, required.Addr().String(), &tls.Config{
+		ServerName:   "a.test",
+		RootCAs:      roots,
+		Certificates: []tls.Certificate{ca.issueClient(t, "reports")},
+	}, raw)
+	assert.True(t, strings.HasPrefix(res, "HTTP/1.1 403 Forbidden\r\n"))
+}
@@ -0,0 +1,106 @@
+// Package servertest provides utilities for testing handlers, in the spirit
+// of net/http/httptest: a server on an ephemeral port, a recorder that
+// captures a response without a socket and a request builder.
+package servertest
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/request"
+	"github.com/DanilShapilov/httpfromtcp/internal/response"
+	"github.com/DanilShapilov/httpfromtcp/internal/server"
+)
+
+// DefaultRemoteAddr is the RemoteAddr of requests made by NewRequest, an
+// address from the documentation range of RFC 5737.
+const DefaultRemoteAddr = "192.0.2.1:1234"
+
+// Server is a server listening on the loopback interface.
+type Server struct {
+	*server.Server
+	// URL is the base URL of the server, like http://127.0.0.1:42069,
+	// without a trailing slash.
+	URL string
+}
+
+// NewTestServer starts a server for handler on an ephemeral loopback port.
+// Callers should Close it when done, typically with t.Cleanup.
+func NewTestServer(handler server.Handler) *Server {
+	return NewTestServerConfig(server.Config{}, handler)
+}
+
+// NewTestServerConfig is like NewTestServer with the limits, timeouts or TLS
+// settings of cfg. An empty Addr binds an ephemeral loopback port. It
+// panics if the server can't start.
+func NewTestServerConfig(cfg server.Config, handler server.Handler) *Server {
+	if cfg.Addr == "" && cfg.Listener == nil {
+		cfg.Addr = "127.0.0.1:0"
+	}
+	srv, err := server.ServeConfig(cfg, handler)
+	if err != nil {
+		panic(fmt.Sprintf("servertest: failed to start server: %v", err))
+	}
+	scheme := "http"
+	if cfg.TLSConfig != nil || len(cfg.KeyPairs) > 0 {
+		scheme = "https"
+	}
+	return &Server{Server: srv, URL: scheme + "://" + srv.Addr().String()}
+}
+
+// ResponseRecorder captures what a handler writes through its Writer, so
+// the handler can be called directly.
+type ResponseRecorder struct {
+	// Writer is passed to the handler.
+	Writer *response.Writer
+	// RequestMethod tells Result whether to expect a body; responses to
+	// HEAD have none whatever their headers say. Defaults to GET.
+	RequestMethod string
+
+	buf bytes.Buffer
+}
+
+func NewRecorder() *ResponseRecorder {
+	rr := &ResponseRecorder{}
+	rr.Writer = response.NewWriter(&rr.buf)
+	return rr
+}
+
+// Bytes returns the response as written on the wire so far.
+func (rr *ResponseRecorder) Bytes() []byte {
+	return rr.buf.Bytes()
+}
+
+// Result parses what the handler wrote: status, headers, the decoded body
+// and the trailers. It fails when the handler didn't write a complete
+// response.
+func (rr *ResponseRecorder) Result() (*response.Response, error) {
+	if err := rr.Writer.Flush(); err != nil {
+		return nil, err
+	}
+	method := rr.RequestMethod
+	if method == "" {
+		method = "GET"
+	}
+	return response.NewReader(bytes.NewReader(rr.buf.Bytes())).ReadResponse(method)
+}
+
+// NewRequest returns a request as a server would hand it to a handler, with
+// Host set to example.com and a Content-Length for a non-nil body. Headers
+// can be changed on the result. NewRequest panics if the request doesn't
+// parse, e.g. because the method is lowercase.
+func NewRequest(method, target string, body []byte) *request.Request {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s HTTP/1.1\r\nHost: example.com\r\n", method, target)
+	if body != nil {
+		fmt.Fprintf(&b, "Content-Length: %d\r\n", len(body))
+	}
+	b.WriteString("\r\n")
+	b.Write(body)
+	req, err := request.RequestFromReader(&b)
+	if err != nil {
+		panic(fmt.Sprintf("servertest: invalid request: %v", err))
+	}
+	req.RemoteAddr = DefaultRemoteAddr
+	return req
+}
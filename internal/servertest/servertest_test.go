@@ -0,0 +1,111 @@
+package servertest
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/client"
+	"github.com/DanilShapilov/httpfromtcp/internal/headers"
+	"github.com/DanilShapilov/httpfromtcp/internal/request"
+	"github.com/DanilShapilov/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echo answers with the request body and the method in a header.
+func echo(w *response.Writer, req *request.Request) {
+	h := response.GetDefaultHeaders(len(req.Body))
+	h.Override("X-Method", req.RequestLine.Method)
+	w.WriteStatusLine(response.StatusCodeSuccess)
+	w.WriteHeaders(h)
+	w.WriteBody(req.Body)
+}
+
+func TestNewRequest(t *testing.T) {
+	// Test: Request without a body
+	req := NewRequest("GET", "/coffee?size=large", nil)
+	assert.Equal(t, request.RequestLine{Method: "GET", RequestTarget: "/coffee?size=large", HttpVersion: "1.1"}, req.RequestLine)
+	host, _ := req.Headers.Get("host")
+	assert.Equal(t, "example.com", host)
+	_, exists := req.Headers.Get("content-length")
+	assert.False(t, exists)
+	assert.Empty(t, req.Body)
+	assert.Equal(t, DefaultRemoteAddr, req.RemoteAddr)
+
+	// Test: Request with a body
+	req = NewRequest("POST", "/users", []byte(`{"user_name": "Nyan"}`))
+	contentLength, _ := req.Headers.Get("content-length")
+	assert.Equal(t, "21", contentLength)
+	assert.Equal(t, `{"user_name": "Nyan"}`, string(req.Body))
+
+	// Test: Invalid requests panic
+	assert.Panics(t, func() { NewRequest("get", "/", nil) })
+}
+
+func TestRecorder(t *testing.T) {
+	// Test: Status, headers and body
+	rr := NewRecorder()
+	echo(rr.Writer, NewRequest("PUT", "/", []byte("hello")))
+	res, err := rr.Result()
+	require.NoError(t, err)
+	assert.Equal(t, response.StatusCodeSuccess, res.StatusLine.StatusCode)
+	method, _ := res.Headers.Get("x-method")
+	assert.Equal(t, "PUT", method)
+	assert.Equal(t, "hello", string(res.Body))
+	assert.True(t, strings.HasPrefix(string(rr.Bytes()), "HTTP/1.1 200 OK\r\n"))
+
+	// Test: Chunked body and trailers
+	rr = NewRecorder()
+	h := response.GetDefaultHeaders(0)
+	h.Remove("Content-Length")
+	h.Override("Transfer-Encoding", "chunked")
+	h.Override("Trailer", "X-Checksum")
+	rr.Writer.WriteStatusLine(response.StatusCodeSuccess)
+	rr.Writer.WriteHeaders(h)
+	rr.Writer.WriteChunkedBody([]byte("hello "))
+	rr.Writer.WriteChunkedBody([]byte("world"))
+	rr.Writer.WriteChunkedBodyDone()
+	rr.Writer.WriteTrailers(headers.Headers{"x-checksum": "abc123"})
+	res, err = rr.Result()
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(res.Body))
+	checksum, _ := res.Trailers.Get("x-checksum")
+	assert.Equal(t, "abc123", checksum)
+
+	// Test: Responses to HEAD have no body
+	rr = NewRecorder()
+	rr.RequestMethod = "HEAD"
+	h = response.GetDefaultHeaders(5)
+	rr.Writer.WriteStatusLine(response.StatusCodeSuccess)
+	rr.Writer.WriteHeaders(h)
+	res, err = rr.Result()
+	require.NoError(t, err)
+	assert.Empty(t, res.Body)
+
+	// Test: Incomplete responses are an error
+	rr = NewRecorder()
+	rr.Writer.WriteStatusLine(response.StatusCodeSuccess)
+	rr.Writer.WriteHeaders(response.GetDefaultHeaders(5))
+	rr.Writer.WriteBody([]byte("he"))
+	_, err = rr.Result()
+	assert.Error(t, err)
+}
+
+func TestServer(t *testing.T) {
+	srv := NewTestServer(echo)
+	t.Cleanup(func() { srv.Close() })
+
+	// Test: The URL reaches the handler
+	assert.True(t, strings.HasPrefix(srv.URL, "http://127.0.0.1:"))
+	req, err := client.NewRequest("POST", srv.URL+"/echo", []byte("ping"))
+	require.NoError(t, err)
+	res, err := client.DefaultClient.Do(context.Background(), req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, response.StatusCodeSuccess, res.StatusCode)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(body))
+}
@@ -0,0 +1,121 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// MinKeySize is the shortest secret NewCodec accepts.
+const MinKeySize = 32
+
+var ErrInvalidCookie = errors.New("session: invalid cookie")
+
+// Codec signs cookie values with HMAC-SHA256 and optionally encrypts them
+// with AES-256-GCM, so clients can neither forge nor read them.
+//
+// New cookies use the first key, incoming ones are checked against every
+// key. To rotate, put a new key first and drop the old one once the
+// cookies it signed have expired.
+type Codec struct {
+	keys    []codecKey
+	encrypt bool
+}
+
+type codecKey struct {
+	aead cipher.AEAD // nil when not encrypting
+	mac  []byte
+}
+
+// NewCodec returns a codec for the given secrets, each at least
+// MinKeySize bytes of random data. The signing and encryption keys are
+// derived from them.
+func NewCodec(encrypt bool, secrets ...[]byte) (*Codec, error) {
+	if len(secrets) == 0 {
+		return nil, errors.New("session: no keys")
+	}
+	c := &Codec{encrypt: encrypt}
+	for i, secret := range secrets {
+		if len(secret) < MinKeySize {
+			return nil, fmt.Errorf("session: key %d is shorter than %d bytes", i, MinKeySize)
+		}
+		k := codecKey{mac: derive(secret, "session signing key")}
+		if encrypt {
+			block, err := aes.NewCipher(derive(secret, "session encryption key"))
+			if err != nil {
+				return nil, err
+			}
+			k.aead, err = cipher.NewGCM(block)
+			if err != nil {
+				return nil, err
+			}
+		}
+		c.keys = append(c.keys, k)
+	}
+	return c, nil
+}
+
+// derive returns a 32 byte key for purpose, so one secret never serves as
+// both signing and encryption key.
+func derive(secret []byte, purpose string) []byte {
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(purpose))
+	return m.Sum(nil)
+}
+
+// Encode returns value as a cookie value bound to the cookie name, so it
+// can't be replayed under another one.
+func (c *Codec) Encode(name, value string) (string, error) {
+	k := c.keys[0]
+	data := []byte(value)
+	if c.encrypt {
+		nonce := make([]byte, k.aead.NonceSize(), k.aead.NonceSize()+len(data)+k.aead.Overhead())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		data = k.aead.Seal(nonce, nonce, data, []byte(name))
+	}
+	data = append(data, mac(k.mac, name, data)...)
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// Decode verifies a value made by Encode for the same cookie name and
+// returns what was encoded, or ErrInvalidCookie.
+func (c *Codec) Decode(name, encoded string) (string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(data) < sha256.Size {
+		return "", ErrInvalidCookie
+	}
+	data, sum := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+	for _, k := range c.keys {
+		if !hmac.Equal(sum, mac(k.mac, name, data)) {
+			continue
+		}
+		if !c.encrypt {
+			return string(data), nil
+		}
+		n := k.aead.NonceSize()
+		if len(data) < n {
+			return "", ErrInvalidCookie
+		}
+		plain, err := k.aead.Open(nil, data[:n], data[n:], []byte(name))
+		if err != nil {
+			return "", ErrInvalidCookie
+		}
+		return string(plain), nil
+	}
+	return "", ErrInvalidCookie
+}
+
+func mac(key []byte, name string, data []byte) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(name))
+	m.Write([]byte{0})
+	m.Write(data)
+	return m.Sum(nil)
+}
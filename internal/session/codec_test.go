@@ -0,0 +1,63 @@
+package session
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	oldKey = bytes.Repeat([]byte("o"), MinKeySize)
+	newKey = bytes.Repeat([]byte("n"), MinKeySize)
+)
+
+func TestCodec(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		c, err := NewCodec(encrypt, oldKey)
+		require.NoError(t, err)
+
+		// Test: Round trip
+		encoded, err := c.Encode("session", "secret id")
+		require.NoError(t, err)
+		value, err := c.Decode("session", encoded)
+		require.NoError(t, err)
+		assert.Equal(t, "secret id", value)
+		assert.Equal(t, encrypt, !strings.Contains(encoded, "c2VjcmV0IGlk"), "encrypt=%v", encrypt)
+
+		// Test: Tampered values, other cookie names and other keys fail
+		tampered := []byte(encoded)
+		tampered[0] ^= 1
+		_, err = c.Decode("session", string(tampered))
+		assert.ErrorIs(t, err, ErrInvalidCookie)
+		_, err = c.Decode("other", encoded)
+		assert.ErrorIs(t, err, ErrInvalidCookie)
+		other, err := NewCodec(encrypt, newKey)
+		require.NoError(t, err)
+		_, err = other.Decode("session", encoded)
+		assert.ErrorIs(t, err, ErrInvalidCookie)
+		for _, garbage := range []string{"", "!!!", "c2hvcnQ"} {
+			_, err = c.Decode("session", garbage)
+			assert.ErrorIs(t, err, ErrInvalidCookie)
+		}
+
+		// Test: Rotation keeps old cookies valid and signs with the new key
+		rotated, err := NewCodec(encrypt, newKey, oldKey)
+		require.NoError(t, err)
+		value, err = rotated.Decode("session", encoded)
+		require.NoError(t, err)
+		assert.Equal(t, "secret id", value)
+		encoded, err = rotated.Encode("session", "secret id")
+		require.NoError(t, err)
+		_, err = c.Decode("session", encoded)
+		assert.ErrorIs(t, err, ErrInvalidCookie)
+	}
+
+	// Test: Key requirements
+	_, err := NewCodec(false)
+	assert.Error(t, err)
+	_, err = NewCodec(true, []byte("too short"))
+	assert.Error(t, err)
+}
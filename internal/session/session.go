@@ -0,0 +1,274 @@
+// Package session keeps per-client state on the server, identified by a
+// signed and optionally encrypted cookie.
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/cookie"
+	"github.com/DanilShapilov/httpfromtcp/internal/headers"
+	"github.com/DanilShapilov/httpfromtcp/internal/request"
+	"github.com/DanilShapilov/httpfromtcp/internal/response"
+	"github.com/DanilShapilov/httpfromtcp/internal/server"
+)
+
+const (
+	DefaultCookieName = "session"
+	DefaultTTL        = 24 * time.Hour
+)
+
+// idSize is the number of random bytes in a session ID.
+const idSize = 32
+
+// Manager loads the session of each request from Store and saves it once
+// the handler changed it. Store and Codec are required.
+type Manager struct {
+	Store Store
+	Codec *Codec
+
+	// CookieName defaults to DefaultCookieName.
+	CookieName string
+	// TTL is how long a session lives after its last change, both in the
+	// store and in the browser. Defaults to DefaultTTL.
+	TTL time.Duration
+	// Cookie holds the Path, Domain, Secure, SameSite and Partitioned
+	// attributes of the session cookie. Path defaults to "/"; the cookie
+	// is always HttpOnly.
+	Cookie cookie.Cookie
+
+	// ErrorLog receives store errors. Defaults to the standard logger.
+	ErrorLog *log.Logger
+}
+
+type sessionKey struct{}
+
+// FromContext returns the session of a request served through
+// Manager.Handler, or nil.
+func FromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(sessionKey{}).(*Session)
+	return s
+}
+
+// Handler returns a Handler that makes the session available to h through
+// FromContext. Changes are saved, and the cookie refreshed, right before h
+// writes its headers. Changes made after that are still saved, but a new
+// session ID can't reach the client anymore.
+func (m *Manager) Handler(h server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		s := m.load(req)
+		w.OnWriteHeaders(func(fields headers.Headers) { m.commit(s, fields, req) })
+		h(w, req.WithContext(context.WithValue(req.Context(), sessionKey{}, s)))
+		m.commit(s, nil, req)
+	}
+}
+
+// load returns the session named by the request cookie, or a new empty
+// one. Clients never choose the ID of a new session.
+func (m *Manager) load(req *request.Request) *Session {
+	s := &Session{values: make(map[string]string), isNew: true}
+	c, err := req.Cookie(m.cookieName())
+	if err != nil {
+		return s
+	}
+	id, err := m.Codec.Decode(m.cookieName(), c.Value)
+	if err != nil || !validID(id) {
+		return s
+	}
+	values, ok, err := m.Store.Load(id)
+	if err != nil {
+		m.logger().Printf("session: load: %v", err)
+		return s
+	}
+	if ok {
+		s.id, s.clientID, s.values, s.isNew = id, id, values, false
+	}
+	return s
+}
+
+// commit saves the pending changes of s and adds the cookie for them to h.
+// h is nil once the headers are written.
+func (m *Manager) commit(s *Session, h headers.Headers, req *request.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, old := range s.staleIDs {
+		if err := m.Store.Delete(old); err != nil {
+			m.logger().Printf("session: delete: %v", err)
+		}
+	}
+	s.staleIDs = nil
+
+	c := m.Cookie
+	c.Name = m.cookieName()
+	c.HttpOnly = true
+	if c.Path == "" {
+		c.Path = "/"
+	}
+	if s.destroyed {
+		if s.clientID != "" && h != nil {
+			c.MaxAge = -1
+			cookie.Set(h, &c)
+			s.clientID = ""
+		}
+		return
+	}
+	if !s.dirty {
+		return
+	}
+	s.dirty = false
+	// an empty new session needs neither a store entry nor a cookie
+	if s.id == "" && len(s.values) == 0 {
+		return
+	}
+	if s.id == "" {
+		s.id = newID()
+	}
+	if err := m.Store.Save(s.id, s.values, m.ttl()); err != nil {
+		m.logger().Printf("session: save: %v", err)
+		return
+	}
+	if h == nil {
+		if s.id != s.clientID {
+			m.logger().Printf("session: new session ID for %s after the headers were written", req.RequestLine.RequestTarget)
+		}
+		return
+	}
+	value, err := m.Codec.Encode(c.Name, s.id)
+	if err != nil {
+		m.logger().Printf("session: encode: %v", err)
+		return
+	}
+	c.Value = value
+	c.MaxAge = int(m.ttl() / time.Second)
+	cookie.Set(h, &c)
+	s.clientID = s.id
+}
+
+func (m *Manager) cookieName() string {
+	if m.CookieName != "" {
+		return m.CookieName
+	}
+	return DefaultCookieName
+}
+
+func (m *Manager) ttl() time.Duration {
+	if m.TTL > 0 {
+		return m.TTL
+	}
+	return DefaultTTL
+}
+
+func (m *Manager) logger() *log.Logger {
+	if m.ErrorLog != nil {
+		return m.ErrorLog
+	}
+	return log.Default()
+}
+
+// Session holds the values of one client. Its methods may be called from
+// several goroutines.
+type Session struct {
+	mu     sync.Mutex
+	id     string // "" until a new session is first saved
+	values map[string]string
+	isNew  bool
+	// clientID is the ID the client holds, counting the cookie of this
+	// response
+	clientID  string
+	dirty     bool
+	destroyed bool
+	// staleIDs are replaced IDs to delete from the store
+	staleIDs []string
+}
+
+// ID returns the session ID, "" for a new session that hasn't been saved
+// yet. It is a secret: never show it to other clients or log it.
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id
+}
+
+// IsNew reports whether the client had no session before this request.
+func (s *Session) IsNew() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isNew
+}
+
+func (s *Session) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.values[key]
+	return v, ok
+}
+
+func (s *Session) Set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	s.dirty = true
+	s.destroyed = false
+}
+
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.values[key]; ok {
+		delete(s.values, key)
+		s.dirty = true
+	}
+}
+
+// Regenerate moves the session to a new ID, keeping its values. Call it
+// whenever the privileges of the client change, on login in particular,
+// so an attacker who planted a session ID beforehand (session fixation)
+// is left with an empty session.
+func (s *Session) Regenerate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.id != "" {
+		s.staleIDs = append(s.staleIDs, s.id)
+	}
+	s.id = newID()
+	s.dirty = true
+	s.destroyed = false
+}
+
+// Destroy deletes the session from the store and expires the cookie, on
+// logout for instance. Setting a value afterwards starts a new session.
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.id != "" {
+		s.staleIDs = append(s.staleIDs, s.id)
+	}
+	s.id = ""
+	s.values = make(map[string]string)
+	s.dirty = false
+	s.destroyed = true
+}
+
+func newID() string {
+	b := make([]byte, idSize)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validID reports whether id looks like one made by newID.
+func validID(id string) bool {
+	if len(id) != 2*idSize {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if c := id[i]; !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,144 @@
+package session
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/cookie"
+	"github.com/DanilShapilov/httpfromtcp/internal/request"
+	"github.com/DanilShapilov/httpfromtcp/internal/response"
+	"github.com/DanilShapilov/httpfromtcp/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// app stores the user on /login, shows it on /whoami, forgets it on
+// /logout and sets it only after the headers on /late.
+func app(w *response.Writer, req *request.Request) {
+	s := FromContext(req.Context())
+	path, query, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+	switch path {
+	case "/login":
+		s.Regenerate()
+		s.Set("user", query)
+	case "/logout":
+		s.Destroy()
+	case "/late":
+		defer s.Set("user", query)
+	}
+	user, _ := s.Get("user")
+	body := []byte(user)
+	w.WriteStatusLine(response.StatusCodeSuccess)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+// do sends target with the session cookie value, if any, and returns the
+// response body and the Set-Cookie of the session, nil without one.
+func do(t *testing.T, handler func(*response.Writer, *request.Request), target, session string) (string, *cookie.Cookie) {
+	t.Helper()
+	req := servertest.NewRequest("GET", target, nil)
+	if session != "" {
+		req.Headers.Set("Cookie", "theme=dark; session="+session)
+	}
+	rr := servertest.NewRecorder()
+	handler(rr.Writer, req)
+	res, err := rr.Result()
+	require.NoError(t, err)
+	lines := res.Headers.Values("set-cookie")
+	if len(lines) == 0 {
+		return string(res.Body), nil
+	}
+	require.Len(t, lines, 1)
+	c, err := cookie.ParseSetCookie(lines[0])
+	require.NoError(t, err)
+	return string(res.Body), c
+}
+
+func TestManager(t *testing.T) {
+	for _, encrypt := range []bool{false, true} {
+		codec, err := NewCodec(encrypt, newKey)
+		require.NoError(t, err)
+		store := NewMemoryStore()
+		var logs bytes.Buffer
+		m := &Manager{
+			Store:    store,
+			Codec:    codec,
+			Cookie:   cookie.Cookie{Secure: true, SameSite: cookie.SameSiteLax},
+			ErrorLog: log.New(&logs, "", 0),
+		}
+		handler := m.Handler(app)
+
+		// Test: Anonymous visits get neither a cookie nor a stored session
+		body, c := do(t, handler, "/whoami", "")
+		assert.Empty(t, body)
+		assert.Nil(t, c)
+		assert.Zero(t, store.Len())
+
+		// Test: Saving a value sets a signed cookie with the attributes
+		body, c = do(t, handler, "/login?nyan", "")
+		assert.Equal(t, "nyan", body)
+		require.NotNil(t, c)
+		assert.Equal(t, "session", c.Name)
+		assert.Equal(t, "/", c.Path)
+		assert.Equal(t, int(DefaultTTL.Seconds()), c.MaxAge)
+		assert.True(t, c.HttpOnly)
+		assert.True(t, c.Secure)
+		assert.Equal(t, cookie.SameSiteLax, c.SameSite)
+		assert.Equal(t, 1, store.Len())
+		session := c.Value
+
+		// Test: The cookie brings the session back, unchanged sessions send no cookie
+		body, c = do(t, handler, "/whoami", session)
+		assert.Equal(t, "nyan", body)
+		assert.Nil(t, c)
+
+		// Test: Forged or unknown cookies start a new session
+		forged := []byte(session)
+		forged[len(forged)/2] ^= 1
+		body, _ = do(t, handler, "/whoami", string(forged))
+		assert.Empty(t, body)
+		unknown, err := codec.Encode("session", newID())
+		require.NoError(t, err)
+		body, _ = do(t, handler, "/whoami", unknown)
+		assert.Empty(t, body)
+
+		// Test: Logging in again moves the session to a new ID
+		_, c = do(t, handler, "/login?prime", session)
+		require.NotNil(t, c)
+		assert.NotEqual(t, session, c.Value)
+		body, _ = do(t, handler, "/whoami", session)
+		assert.Empty(t, body, "old ID still valid")
+		assert.Equal(t, 1, store.Len())
+		session = c.Value
+
+		// Test: Logging out expires the cookie and deletes the session
+		_, c = do(t, handler, "/logout", session)
+		require.NotNil(t, c)
+		assert.Equal(t, -1, c.MaxAge)
+		assert.Zero(t, store.Len())
+
+		// Test: Changes after the headers are saved, a new ID is reported
+		_, c = do(t, handler, "/late?late", "")
+		assert.Nil(t, c)
+		assert.Equal(t, 1, store.Len())
+		assert.Contains(t, logs.String(), "after the headers were written")
+	}
+}
+
+func TestFixation(t *testing.T) {
+	codec, err := NewCodec(false, newKey)
+	require.NoError(t, err)
+	m := &Manager{Store: NewMemoryStore(), Codec: codec, ErrorLog: log.New(io.Discard, "", 0)}
+	handler := m.Handler(app)
+
+	// Test: A session planted by an attacker isn't the one the victim logs in to
+	_, attacker := do(t, handler, "/login?attacker", "")
+	_, victim := do(t, handler, "/login?victim", attacker.Value)
+	assert.NotEqual(t, attacker.Value, victim.Value)
+	body, _ := do(t, handler, "/whoami", attacker.Value)
+	assert.Empty(t, body)
+}
@@ -0,0 +1,247 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store keeps session values on the server between requests. Stores must
+// be safe for concurrent use.
+type Store interface {
+	// Load returns the values of session id, with ok false when there is
+	// no such session or it expired.
+	Load(id string) (values map[string]string, ok bool, err error)
+	// Save replaces the values of session id and keeps them for ttl.
+	Save(id string, values map[string]string, ttl time.Duration) error
+	// Delete removes session id. Deleting a missing session is no error.
+	Delete(id string) error
+}
+
+// MemoryStore keeps sessions in memory, they are lost on restart. Expired
+// sessions are never loaded; StartEviction frees their memory.
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]memoryEntry
+
+	now func() time.Time
+}
+
+type memoryEntry struct {
+	values  map[string]string
+	expires time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: make(map[string]memoryEntry), now: time.Now}
+}
+
+func (s *MemoryStore) Load(id string) (map[string]string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.sessions[id]
+	if !ok || !s.now().Before(e.expires) {
+		return nil, false, nil
+	}
+	return cloneValues(e.values), true, nil
+}
+
+func (s *MemoryStore) Save(id string, values map[string]string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[id] = memoryEntry{values: cloneValues(values), expires: s.now().Add(ttl)}
+	return nil
+}
+
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+	return nil
+}
+
+// Len returns the number of sessions held, expired ones included.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.sessions)
+}
+
+// Evict drops the expired sessions.
+func (s *MemoryStore) Evict() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	for id, e := range s.sessions {
+		if !now.Before(e.expires) {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+// StartEviction calls Evict every interval until the returned stop
+// function is called.
+func (s *MemoryStore) StartEviction(interval time.Duration) (stop func()) {
+	return startEviction(interval, func() { s.Evict() })
+}
+
+// FileStore keeps each session in a JSON file of its own in a directory,
+// so sessions survive restarts. It is meant for a single process.
+type FileStore struct {
+	dir string
+	now func() time.Time
+}
+
+type fileEntry struct {
+	Values  map[string]string `json:"values"`
+	Expires time.Time         `json:"expires"`
+}
+
+// NewFileStore stores sessions in dir, creating it if needed. Only the
+// owner can read the files.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir, now: time.Now}, nil
+}
+
+// path returns the file of session id. IDs come from our own cookies, but
+// they are checked anyway so they can never point outside dir.
+func (s *FileStore) path(id string) (string, error) {
+	if !validID(id) {
+		return "", errors.New("session: invalid id")
+	}
+	return filepath.Join(s.dir, id+".json"), nil
+}
+
+func (s *FileStore) Load(id string) (map[string]string, bool, error) {
+	name, err := s.path(id)
+	if err != nil {
+		return nil, false, nil
+	}
+	e, err := readFileEntry(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if !s.now().Before(e.Expires) {
+		os.Remove(name)
+		return nil, false, nil
+	}
+	return e.Values, true, nil
+}
+
+func readFileEntry(name string) (fileEntry, error) {
+	var e fileEntry
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return e, err
+	}
+	err = json.Unmarshal(data, &e)
+	return e, err
+}
+
+// Save writes the session to a temporary file first and renames it, so a
+// crash never leaves a partial session behind.
+func (s *FileStore) Save(id string, values map[string]string, ttl time.Duration) error {
+	name, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(fileEntry{Values: values, Expires: s.now().Add(ttl)})
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func (s *FileStore) Delete(id string) error {
+	name, err := s.path(id)
+	if err != nil {
+		return nil
+	}
+	if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Evict removes the files of expired sessions, as well as unreadable ones.
+func (s *FileStore) Evict() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	now := s.now()
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || !validID(id) {
+			continue
+		}
+		name := filepath.Join(s.dir, entry.Name())
+		e, err := readFileEntry(name)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) || err == nil && !now.Before(e.Expires) {
+			os.Remove(name)
+		}
+	}
+	return nil
+}
+
+// StartEviction calls Evict every interval until the returned stop
+// function is called.
+func (s *FileStore) StartEviction(interval time.Duration) (stop func()) {
+	return startEviction(interval, func() { s.Evict() })
+}
+
+func startEviction(interval time.Duration, evict func()) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				evict()
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+		})
+	}
+}
+
+func cloneValues(values map[string]string) map[string]string {
+	out := make(map[string]string, len(values))
+	for k, v := range values {
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,98 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// clock is a settable time source for the stores.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time { return c.t }
+
+func testStore(t *testing.T, s Store, evict func()) {
+	t.Helper()
+	id := newID()
+
+	// Test: Save and Load, values are copied
+	values := map[string]string{"user": "nyan"}
+	require.NoError(t, s.Save(id, values, time.Minute))
+	values["user"] = "changed"
+	loaded, ok, err := s.Load(id)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, map[string]string{"user": "nyan"}, loaded)
+
+	// Test: Missing sessions
+	_, ok, err = s.Load(newID())
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// Test: Delete, twice
+	require.NoError(t, s.Delete(id))
+	require.NoError(t, s.Delete(id))
+	_, ok, _ = s.Load(id)
+	assert.False(t, ok)
+
+	// Test: Expired sessions are not loaded, then evicted
+	require.NoError(t, s.Save(id, values, time.Minute))
+	require.NoError(t, s.Save(newID(), values, time.Hour))
+	evict()
+	_, ok, _ = s.Load(id)
+	assert.False(t, ok)
+}
+
+func TestMemoryStore(t *testing.T) {
+	c := &clock{t: time.Now()}
+	s := NewMemoryStore()
+	s.now = c.now
+	testStore(t, s, func() {
+		c.t = c.t.Add(2 * time.Minute)
+		s.Evict()
+		assert.Equal(t, 1, s.Len())
+	})
+
+	// Test: Background eviction stops when asked to
+	s.Save(newID(), nil, time.Nanosecond)
+	c.t = c.t.Add(time.Hour)
+	stop := s.StartEviction(time.Millisecond)
+	require.Eventually(t, func() bool { return s.Len() == 0 }, time.Second, time.Millisecond)
+	stop()
+	stop()
+}
+
+func TestFileStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "sessions")
+	c := &clock{t: time.Now()}
+	s, err := NewFileStore(dir)
+	require.NoError(t, err)
+	s.now = c.now
+	testStore(t, s, func() {
+		c.t = c.t.Add(2 * time.Minute)
+		require.NoError(t, s.Evict())
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Len(t, entries, 1)
+	})
+
+	// Test: Sessions survive a new store on the same directory
+	id := newID()
+	require.NoError(t, s.Save(id, map[string]string{"a": "1"}, time.Hour))
+	reopened, err := NewFileStore(dir)
+	require.NoError(t, err)
+	loaded, ok, err := reopened.Load(id)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "1", loaded["a"])
+
+	// Test: IDs can't name files outside the directory
+	assert.Error(t, s.Save("../escape", nil, time.Hour))
+	_, ok, err = s.Load("../escape")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,225 @@
+// Package sse streams Server-Sent Events to browsers over a chunked
+// response, as described in the HTML Living Standard section 9.2.
+package sse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/headers"
+	"github.com/DanilShapilov/httpfromtcp/internal/request"
+	"github.com/DanilShapilov/httpfromtcp/internal/response"
+)
+
+// DefaultHeartbeat is used when NewStream is given a heartbeat of 0. It is
+// well below the idle timeouts common in proxies and load balancers.
+const DefaultHeartbeat = 15 * time.Second
+
+var (
+	ErrClosed       = errors.New("sse: stream closed")
+	ErrInvalidEvent = errors.New("sse: invalid event")
+)
+
+// Event is a single message. Empty fields are left out.
+type Event struct {
+	// ID becomes the client's last event ID, sent back in Last-Event-ID
+	// when it reconnects.
+	ID string
+	// Event is the event type; clients treat an empty one as "message".
+	Event string
+	// Data may span several lines.
+	Data string
+	// Retry tells the client how long to wait before reconnecting.
+	Retry time.Duration
+}
+
+// encode renders e in the text/event-stream format, terminated by the blank
+// line that makes the client dispatch it.
+func (e Event) encode() ([]byte, error) {
+	if strings.ContainsAny(e.ID, "\r\n\x00") {
+		return nil, fmt.Errorf("%w: id contains a line break or NUL", ErrInvalidEvent)
+	}
+	if strings.ContainsAny(e.Event, "\r\n") {
+		return nil, fmt.Errorf("%w: event type contains a line break", ErrInvalidEvent)
+	}
+	var b strings.Builder
+	if e.Retry > 0 {
+		fmt.Fprintf(&b, "retry: %d\n", e.Retry.Milliseconds())
+	}
+	if e.ID != "" {
+		fmt.Fprintf(&b, "id: %s\n", e.ID)
+	}
+	if e.Event != "" {
+		fmt.Fprintf(&b, "event: %s\n", e.Event)
+	}
+	// an event that only sets the id or the retry delay must not be
+	// dispatched as an empty message
+	if e.Data != "" || e.Event != "" || (e.ID == "" && e.Retry <= 0) {
+		data := strings.ReplaceAll(e.Data, "\r\n", "\n")
+		data = strings.ReplaceAll(data, "\r", "\n")
+		for _, line := range strings.Split(data, "\n") {
+			fmt.Fprintf(&b, "data: %s\n", line)
+		}
+	}
+	b.WriteString("\n")
+	return []byte(b.String()), nil
+}
+
+// Stream writes events to one client. Send and Comment may be called from
+// several goroutines.
+type Stream struct {
+	w           *response.Writer
+	ctx         context.Context
+	lastEventID string
+
+	mu     sync.Mutex
+	closed bool
+	err    error // first write error, the client is gone
+
+	stopHeartbeat chan struct{}
+	heartbeatDone chan struct{}
+}
+
+// NewStream answers req with an event stream and sends a comment every
+// heartbeat so idle connections aren't dropped by intermediaries. A
+// heartbeat of 0 uses DefaultHeartbeat and a negative one disables it.
+// The server's WriteTimeout applies to the whole stream, so servers that
+// stream events should leave it unset.
+func NewStream(w *response.Writer, req *request.Request, heartbeat time.Duration) (*Stream, error) {
+	h := headers.NewHeaders()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Connection", "close")
+	if err := w.WriteStatusLine(response.StatusCodeSuccess); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return nil, err
+	}
+	// let the client know the stream is open before the first event
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	lastEventID, _ := req.Headers.Get("last-event-id")
+	s := &Stream{
+		w:             w,
+		ctx:           req.Context(),
+		lastEventID:   lastEventID,
+		stopHeartbeat: make(chan struct{}),
+		heartbeatDone: make(chan struct{}),
+	}
+	if heartbeat == 0 {
+		heartbeat = DefaultHeartbeat
+	}
+	if heartbeat > 0 {
+		go s.heartbeat(heartbeat)
+	} else {
+		close(s.heartbeatDone)
+	}
+	return s, nil
+}
+
+// LastEventID returns the ID of the last event the client saw before
+// reconnecting, or "" on its first connection. Handlers use it to replay
+// the events the client missed.
+func (s *Stream) LastEventID() string {
+	return s.lastEventID
+}
+
+// Done is closed once the client disconnects or the request context ends
+// otherwise. Nothing more can be sent after that.
+func (s *Stream) Done() <-chan struct{} {
+	return s.ctx.Done()
+}
+
+// Send writes e to the client.
+func (s *Stream) Send(e Event) error {
+	b, err := e.encode()
+	if err != nil {
+		return err
+	}
+	return s.write(b)
+}
+
+// Comment writes a comment line, which clients ignore.
+func (s *Stream) Comment(text string) error {
+	var b strings.Builder
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r", ""), "\n") {
+		fmt.Fprintf(&b, ": %s\n", line)
+	}
+	b.WriteString("\n")
+	return s.write([]byte(b.String()))
+}
+
+func (s *Stream) write(b []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if s.err != nil {
+		return s.err
+	}
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	_, err := s.w.WriteChunkedBody(b)
+	if err == nil {
+		err = s.w.Flush()
+	}
+	if err != nil {
+		s.err = err
+		return err
+	}
+	return nil
+}
+
+func (s *Stream) heartbeat(interval time.Duration) {
+	defer close(s.heartbeatDone)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Comment("heartbeat"); err != nil {
+				return
+			}
+		case <-s.stopHeartbeat:
+			return
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// Close stops the heartbeat and ends the response. Clients reconnect after
+// the retry delay unless they are told otherwise, e.g. by an event the
+// application defines for that.
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+	close(s.stopHeartbeat)
+	<-s.heartbeatDone
+
+	if s.err != nil || s.ctx.Err() != nil {
+		return nil
+	}
+	if _, err := s.w.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+	if err := s.w.WriteTrailers(headers.NewHeaders()); err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
@@ -0,0 +1,130 @@
+package sse
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/request"
+	"github.com/DanilShapilov/httpfromtcp/internal/response"
+	"github.com/DanilShapilov/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// open requests an event stream from addr and returns the response along
+// with a line reader over its decoded body.
+func open(t *testing.T, addr, extra string) (net.Conn, *response.Response, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	fmt.Fprintf(conn, "GET /events HTTP/1.1\r\nHost: %s\r\n%s\r\n", addr, extra)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	res, err := response.NewReader(conn).ReadResponseHeader("GET")
+	require.NoError(t, err)
+	return conn, res, bufio.NewReader(res.BodyReader())
+}
+
+// readEvent returns the lines of the next event, up to the blank line.
+func readEvent(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	var b strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\n" {
+			return b.String()
+		}
+		b.WriteString(line)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	// Test: All fields, multi-line data with mixed line endings
+	b, err := Event{ID: "7", Event: "update", Data: "a\r\nb\rc\nd", Retry: 3 * time.Second}.encode()
+	require.NoError(t, err)
+	assert.Equal(t, "retry: 3000\nid: 7\nevent: update\ndata: a\ndata: b\ndata: c\ndata: d\n\n", string(b))
+
+	// Test: An empty event is dispatched as an empty message
+	b, err = Event{}.encode()
+	require.NoError(t, err)
+	assert.Equal(t, "data: \n\n", string(b))
+
+	// Test: Retry and id alone are not dispatched
+	b, err = Event{ID: "8", Retry: time.Second}.encode()
+	require.NoError(t, err)
+	assert.Equal(t, "retry: 1000\nid: 8\n\n", string(b))
+
+	// Test: Line breaks in the id or type are rejected
+	_, err = Event{ID: "1\n2"}.encode()
+	assert.ErrorIs(t, err, ErrInvalidEvent)
+	_, err = Event{Event: "a\rb"}.encode()
+	assert.ErrorIs(t, err, ErrInvalidEvent)
+}
+
+func TestStream(t *testing.T) {
+	done := make(chan error, 1)
+	srv, err := server.ServeConfig(server.Config{Addr: "127.0.0.1:0"}, func(w *response.Writer, req *request.Request) {
+		s, err := NewStream(w, req, 20*time.Millisecond)
+		if err != nil {
+			done <- err
+			return
+		}
+		defer s.Close()
+		s.Send(Event{ID: "1", Data: "resumed after " + s.LastEventID()})
+		<-s.Done()
+		done <- s.Send(Event{Data: "too late"})
+	})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	// Test: Event stream headers and Last-Event-ID
+	conn, res, body := open(t, srv.Addr().String(), "Last-Event-ID: 41\r\n")
+	assert.Equal(t, response.StatusCodeSuccess, res.StatusLine.StatusCode)
+	contentType, _ := res.Headers.Get("content-type")
+	assert.Equal(t, "text/event-stream", contentType)
+	cacheControl, _ := res.Headers.Get("cache-control")
+	assert.Equal(t, "no-cache", cacheControl)
+	assert.Equal(t, "id: 1\ndata: resumed after 41\n", readEvent(t, body))
+
+	// Test: Heartbeat comments while idle
+	assert.Equal(t, ": heartbeat\n", readEvent(t, body))
+	assert.Equal(t, ": heartbeat\n", readEvent(t, body))
+
+	// Test: Client disconnect ends the stream
+	conn.Close()
+	select {
+	case err := <-done:
+		assert.Error(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream not done after client disconnect")
+	}
+}
+
+func TestClose(t *testing.T) {
+	srv, err := server.ServeConfig(server.Config{Addr: "127.0.0.1:0"}, func(w *response.Writer, req *request.Request) {
+		s, err := NewStream(w, req, -1)
+		if err != nil {
+			return
+		}
+		s.Comment("two\nlines")
+		s.Send(Event{Event: "bye"})
+		s.Close()
+		assert.ErrorIs(t, s.Send(Event{Data: "after close"}), ErrClosed)
+	})
+	require.NoError(t, err)
+	defer srv.Close()
+
+	// Test: Close ends the chunked body after the pending events
+	_, res, body := open(t, srv.Addr().String(), "")
+	assert.True(t, res.Chunked())
+	assert.Equal(t, ": two\n: lines\n", readEvent(t, body))
+	assert.Equal(t, "event: bye\ndata: \n", readEvent(t, body))
+	_, err = body.ReadByte()
+	assert.Error(t, err)
+	assert.True(t, res.Complete())
+}
@@ -0,0 +1,42 @@
+package tracing
+
+import (
+	"strings"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/request"
+	"github.com/DanilShapilov/httpfromtcp/internal/response"
+	"github.com/DanilShapilov/httpfromtcp/internal/server"
+)
+
+// Handler returns a Handler that wraps h in a server span. The span
+// continues the caller's trace when the request has a valid traceparent
+// and is available to h through req.Context(). route labels the span; nil
+// means the request path without the query string.
+func (t *Tracer) Handler(h server.Handler, route func(*request.Request) string) server.Handler {
+	if route == nil {
+		route = func(req *request.Request) string {
+			path, _, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+			return path
+		}
+	}
+	return func(w *response.Writer, req *request.Request) {
+		ctx := req.Context()
+		if sc, ok := Extract(req.Headers); ok {
+			ctx = ContextWithRemoteSpanContext(ctx, sc)
+		}
+		r := route(req)
+		ctx, span := t.Start(ctx, req.RequestLine.Method+" "+r, SpanKindServer)
+		defer span.End()
+		span.SetAttribute("http.request.method", req.RequestLine.Method)
+		span.SetAttribute("http.route", r)
+		span.SetAttribute("url.path", req.RequestLine.RequestTarget)
+
+		h(w, req.WithContext(ctx))
+
+		status := int(w.StatusCode())
+		span.SetAttribute("http.response.status_code", status)
+		if status >= 500 {
+			span.SetStatus(StatusError, "")
+		}
+	}
+}
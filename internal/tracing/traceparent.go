@@ -0,0 +1,120 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/headers"
+)
+
+// TraceID identifies a whole trace across services.
+type TraceID [16]byte
+
+// SpanID identifies a single span within a trace.
+type SpanID [8]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+func (id TraceID) IsValid() bool  { return id != TraceID{} }
+func (id SpanID) IsValid() bool   { return id != SpanID{} }
+
+// FlagSampled is the only trace flag defined by W3C Trace Context.
+const FlagSampled byte = 0x01
+
+// SpanContext is the part of a span that crosses process boundaries.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Flags      byte
+	TraceState string // opaque vendor data, forwarded untouched
+	Remote     bool   // true when parsed from incoming headers
+}
+
+func (sc SpanContext) IsValid() bool { return sc.TraceID.IsValid() && sc.SpanID.IsValid() }
+
+func (sc SpanContext) Sampled() bool { return sc.Flags&FlagSampled != 0 }
+
+// Traceparent formats sc as a version 00 traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, sc.Flags)
+}
+
+var ErrInvalidTraceparent = errors.New("invalid traceparent")
+
+// ParseTraceparent parses a traceparent header value as defined by W3C Trace
+// Context: version-traceid-parentid-flags, all lowercase hex. Versions
+// newer than 00 are accepted as long as the known fields are well formed.
+func ParseTraceparent(value string) (SpanContext, error) {
+	var sc SpanContext
+	value = strings.TrimSpace(value)
+	parts := strings.Split(value, "-")
+	if len(parts) < 4 {
+		return sc, ErrInvalidTraceparent
+	}
+	version, traceID, spanID, flags := parts[0], parts[1], parts[2], parts[3]
+	if len(version) != 2 || !isLowerHex(version) || version == "ff" {
+		return sc, ErrInvalidTraceparent
+	}
+	if version == "00" && len(parts) != 4 {
+		return sc, ErrInvalidTraceparent
+	}
+	if len(traceID) != 32 || !isLowerHex(traceID) ||
+		len(spanID) != 16 || !isLowerHex(spanID) ||
+		len(flags) != 2 || !isLowerHex(flags) {
+		return sc, ErrInvalidTraceparent
+	}
+	hex.Decode(sc.TraceID[:], []byte(traceID))
+	hex.Decode(sc.SpanID[:], []byte(spanID))
+	var f [1]byte
+	hex.Decode(f[:], []byte(flags))
+	sc.Flags = f[0]
+	if !sc.IsValid() {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	return sc, nil
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Extract reads traceparent and tracestate from request headers. It returns
+// false when there is no valid traceparent, in which case a new trace
+// should be started.
+func Extract(h headers.Headers) (SpanContext, bool) {
+	value, exists := h.Get("traceparent")
+	if !exists {
+		return SpanContext{}, false
+	}
+	sc, err := ParseTraceparent(value)
+	if err != nil {
+		return SpanContext{}, false
+	}
+	sc.TraceState, _ = h.Get("tracestate")
+	sc.Remote = true
+	return sc, true
+}
+
+// Inject writes the span context carried by ctx as traceparent and
+// tracestate headers through set, which can be headers.Headers.Override or
+// the Set method of any other header type. It does nothing when ctx
+// carries no span.
+func Inject(ctx context.Context, set func(key, value string)) {
+	sc := SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	set("traceparent", sc.Traceparent())
+	if sc.TraceState != "" {
+		set("tracestate", sc.TraceState)
+	}
+}
@@ -0,0 +1,127 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/headers"
+	"github.com/DanilShapilov/httpfromtcp/internal/request"
+	"github.com/DanilShapilov/httpfromtcp/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestParseTraceparent(t *testing.T) {
+	// Test: Valid traceparent round-trips
+	sc, err := ParseTraceparent(traceparent)
+	require.NoError(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	assert.True(t, sc.Sampled())
+	assert.Equal(t, traceparent, sc.Traceparent())
+
+	// Test: Future versions may append fields
+	_, err = ParseTraceparent("cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra")
+	require.NoError(t, err)
+
+	// Test: Invalid values
+	for _, value := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+	} {
+		_, err = ParseTraceparent(value)
+		assert.ErrorIs(t, err, ErrInvalidTraceparent, value)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	exporter := NewInMemoryExporter()
+	tracer := NewTracer(exporter)
+	var outbound headers.Headers
+	h := tracer.Handler(func(w *response.Writer, req *request.Request) {
+		// an outbound call made by the handler
+		ctx, span := tracer.Start(req.Context(), "GET upstream", SpanKindClient)
+		outbound = headers.NewHeaders()
+		Inject(ctx, outbound.Override)
+		span.End()
+
+		w.WriteStatusLine(response.StatusCodeInternalServerError)
+		w.WriteHeaders(response.GetDefaultHeaders(0))
+	}, nil)
+
+	// Test: Incoming traceparent is continued and propagated
+	in := headers.NewHeaders()
+	in.Set("Traceparent", traceparent)
+	in.Set("Tracestate", "vendor=value")
+	h(response.NewWriter(io.Discard), &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: "/coffee?size=large", HttpVersion: "1.1"},
+		Headers:     in,
+	})
+
+	spans := exporter.Spans()
+	require.Len(t, spans, 2)
+	client, server := spans[0], spans[1]
+	assert.Equal(t, "GET /coffee", server.Name)
+	assert.Equal(t, SpanKindServer, server.Kind)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.TraceID)
+	assert.Equal(t, "00f067aa0ba902b7", server.ParentSpanID)
+	assert.Equal(t, "vendor=value", server.TraceState)
+	assert.Equal(t, "GET", server.Attributes["http.request.method"])
+	assert.Equal(t, "/coffee", server.Attributes["http.route"])
+	assert.Equal(t, 500, server.Attributes["http.response.status_code"])
+	assert.Equal(t, StatusError, server.Status)
+	assert.Equal(t, server.TraceID, client.TraceID)
+	assert.Equal(t, server.SpanID, client.ParentSpanID)
+	assert.Equal(t, "00-"+client.TraceID+"-"+client.SpanID+"-01", outbound["traceparent"])
+	assert.Equal(t, "vendor=value", outbound["tracestate"])
+
+	// Test: Requests without traceparent start a new trace
+	exporter.Reset()
+	h(response.NewWriter(io.Discard), &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: "/", HttpVersion: "1.1"},
+		Headers:     headers.NewHeaders(),
+	})
+	spans = exporter.Spans()
+	require.Len(t, spans, 2)
+	assert.Empty(t, spans[1].ParentSpanID)
+	assert.NotEqual(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[1].TraceID)
+
+	// Test: Unsampled traces are not exported
+	exporter.Reset()
+	in = headers.NewHeaders()
+	in.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	h(response.NewWriter(io.Discard), &request.Request{
+		RequestLine: request.RequestLine{Method: "GET", RequestTarget: "/", HttpVersion: "1.1"},
+		Headers:     in,
+	})
+	assert.Empty(t, exporter.Spans())
+	assert.Equal(t, "00", outbound["traceparent"][53:])
+}
+
+func TestJSONExporter(t *testing.T) {
+	// Test: One JSON line per span
+	var out bytes.Buffer
+	tracer := NewTracer(NewJSONExporter(&out))
+	_, span := tracer.Start(context.Background(), "work", SpanKindInternal)
+	span.SetAttribute("answer", 42)
+	span.End()
+	span.End()
+
+	var data map[string]any
+	require.NoError(t, json.Unmarshal(out.Bytes(), &data))
+	assert.Equal(t, "work", data["name"])
+	assert.Equal(t, "internal", data["kind"])
+	assert.Equal(t, float64(42), data["attributes"].(map[string]any)["answer"])
+	assert.Equal(t, 1, bytes.Count(out.Bytes(), []byte("\n")))
+}
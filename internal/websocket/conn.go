@@ -0,0 +1,389 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+type MessageType int
+
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+)
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// Close codes from RFC 6455 section 7.4.1.
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005 // never sent, reported when the peer gave no code
+	CloseAbnormal        = 1006 // never sent, reported when the connection dropped
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+// closeTimeout bounds how long Close waits for the peer to answer.
+const closeTimeout = 5 * time.Second
+
+var ErrCloseSent = errors.New("websocket: close already sent")
+
+// CloseError is returned by ReadMessage once the connection is closed, with
+// the code and reason the peer sent, or the ones we failed the connection
+// with.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket: close %d", e.Code)
+	}
+	return fmt.Sprintf("websocket: close %d: %s", e.Code, e.Reason)
+}
+
+// Conn is a WebSocket connection. One goroutine may read while others write;
+// writes are serialized.
+type Conn struct {
+	// Subprotocol is the one agreed on in the handshake, if any.
+	Subprotocol string
+
+	conn     net.Conn
+	br       *bufio.Reader
+	isServer bool
+	maxSize  int
+
+	wmu       sync.Mutex
+	closeSent bool
+
+	closeReceived bool // only touched by the reader
+}
+
+// newConn wraps conn, reading buffered first: frames the peer sent right
+// after the handshake may already have been read by the server.
+func newConn(conn net.Conn, buffered []byte, isServer bool, maxSize int) *Conn {
+	var r io.Reader = conn
+	if len(buffered) > 0 {
+		r = io.MultiReader(bytes.NewReader(buffered), conn)
+	}
+	return &Conn{
+		conn:     conn,
+		br:       bufio.NewReader(r),
+		isServer: isServer,
+		maxSize:  maxSize,
+	}
+}
+
+type frame struct {
+	fin     bool
+	opcode  byte
+	payload []byte
+}
+
+// ReadMessage returns the next data message, reassembled from its fragments.
+// Pings are answered and pongs dropped along the way. When the peer closes,
+// or breaks the protocol, the close handshake is completed and a
+// *CloseError returned.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	var typ MessageType
+	var msg []byte
+	for {
+		f, err := c.readFrame(c.maxSize - len(msg))
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+		switch f.opcode {
+		case opPing:
+			if err := c.writeFrame(opPong, f.payload); err != nil && !errors.Is(err, ErrCloseSent) {
+				return 0, nil, c.fail(err)
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			return 0, nil, c.handleClose(f.payload)
+		case opText, opBinary:
+			if typ != 0 {
+				return 0, nil, c.fail(&CloseError{CloseProtocolError, "new message inside a fragmented one"})
+			}
+			typ = MessageType(f.opcode)
+		case opContinuation:
+			if typ == 0 {
+				return 0, nil, c.fail(&CloseError{CloseProtocolError, "continuation without a message"})
+			}
+		default:
+			return 0, nil, c.fail(&CloseError{CloseProtocolError, fmt.Sprintf("unknown opcode %d", f.opcode)})
+		}
+		msg = append(msg, f.payload...)
+		if f.fin {
+			if typ == TextMessage && !utf8.Valid(msg) {
+				return 0, nil, c.fail(&CloseError{CloseInvalidPayload, "text message is not UTF-8"})
+			}
+			return typ, msg, nil
+		}
+	}
+}
+
+// readFrame reads one frame whose payload, for data frames, may not exceed
+// limit bytes. Protocol violations are returned as *CloseError.
+func (c *Conn) readFrame(limit int) (frame, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c.br, hdr[:]); err != nil {
+		return frame{}, err
+	}
+	f := frame{fin: hdr[0]&0x80 != 0, opcode: hdr[0] & 0x0F}
+	if hdr[0]&0x70 != 0 {
+		return frame{}, &CloseError{CloseProtocolError, "reserved bits set"}
+	}
+	// clients must mask their frames and servers must not
+	masked := hdr[1]&0x80 != 0
+	if masked != c.isServer {
+		return frame{}, &CloseError{CloseProtocolError, "bad masking"}
+	}
+
+	length := uint64(hdr[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return frame{}, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return frame{}, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+		if length>>63 != 0 {
+			return frame{}, &CloseError{CloseProtocolError, "invalid length"}
+		}
+	}
+	if f.opcode >= opClose {
+		if !f.fin || length > 125 {
+			return frame{}, &CloseError{CloseProtocolError, "invalid control frame"}
+		}
+	} else if length > uint64(limit) {
+		return frame{}, &CloseError{CloseMessageTooBig, "message too big"}
+	}
+
+	var key [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, key[:]); err != nil {
+			return frame{}, err
+		}
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, f.payload); err != nil {
+		return frame{}, err
+	}
+	if masked {
+		maskBytes(key, f.payload)
+	}
+	return f, nil
+}
+
+// fail ends the connection after a read error. Protocol violations are
+// reported to the peer with a close frame first.
+func (c *Conn) fail(err error) error {
+	var closeErr *CloseError
+	if errors.As(err, &closeErr) {
+		c.writeClose(closeErr.Code, closeErr.Reason)
+		c.conn.Close()
+		return closeErr
+	}
+	c.conn.Close()
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return &CloseError{Code: CloseAbnormal}
+	}
+	return err
+}
+
+// handleClose answers the peer's close frame and closes the connection.
+func (c *Conn) handleClose(payload []byte) error {
+	c.closeReceived = true
+	closeErr := &CloseError{Code: CloseNoStatus}
+	switch {
+	case len(payload) == 1:
+		return c.fail(&CloseError{CloseProtocolError, "invalid close payload"})
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+		if !validCloseCode(closeErr.Code) {
+			return c.fail(&CloseError{CloseProtocolError, "invalid close code"})
+		}
+		if !utf8.ValidString(closeErr.Reason) {
+			return c.fail(&CloseError{CloseInvalidPayload, "close reason is not UTF-8"})
+		}
+	}
+	if closeErr.Code == CloseNoStatus {
+		c.writeFrame(opClose, nil)
+	} else {
+		c.writeClose(closeErr.Code, "")
+	}
+	c.conn.Close()
+	return closeErr
+}
+
+// validCloseCode reports whether code may appear in a close frame.
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 3000 && code <= 4999:
+		return true
+	case code < 1000 || code > 1014:
+		return false
+	}
+	switch code {
+	case 1004, CloseNoStatus, CloseAbnormal:
+		return false
+	}
+	return true
+}
+
+// WriteMessage sends data as a single frame.
+func (c *Conn) WriteMessage(typ MessageType, data []byte) error {
+	if typ != TextMessage && typ != BinaryMessage {
+		return fmt.Errorf("websocket: invalid message type %d", typ)
+	}
+	return c.writeFrame(byte(typ), data)
+}
+
+// Ping sends a ping, which the peer answers with a pong carrying data.
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > 125 {
+		return errors.New("websocket: ping payload too long")
+	}
+	return c.writeFrame(opPing, data)
+}
+
+// Close starts the close handshake, waits for the peer's answer and closes
+// the connection. It must not be called while ReadMessage runs; a reader
+// that gets the peer's answer completes the handshake by itself.
+func (c *Conn) Close(code int, reason string) error {
+	err := c.writeClose(code, reason)
+	if c.closeReceived {
+		c.conn.Close()
+		return nil
+	}
+	if err == nil {
+		c.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+		for {
+			f, err := c.readFrame(c.maxSize)
+			if err != nil || f.opcode == opClose {
+				break
+			}
+		}
+	}
+	c.conn.Close()
+	if errors.Is(err, ErrCloseSent) {
+		return nil
+	}
+	return err
+}
+
+func (c *Conn) writeClose(code int, reason string) error {
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	payload := make([]byte, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	copy(payload[2:], reason)
+	return c.writeFrame(opClose, payload)
+}
+
+// writeFrame sends a final frame, masked when we are the client. Nothing can
+// be sent after a close frame.
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	if opcode == opClose {
+		c.closeSent = true
+	}
+	return c.writeFrameLocked(true, opcode, payload)
+}
+
+func (c *Conn) writeFrameLocked(fin bool, opcode byte, payload []byte) error {
+	buf := make([]byte, 0, 14+len(payload))
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	buf = append(buf, b0)
+
+	var maskBit byte
+	if !c.isServer {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		buf = append(buf, maskBit|byte(n))
+	case n <= 0xFFFF:
+		buf = append(buf, maskBit|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(n))
+	default:
+		buf = append(buf, maskBit|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(n))
+	}
+
+	if c.isServer {
+		buf = append(buf, payload...)
+	} else {
+		var key [4]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			return err
+		}
+		buf = append(buf, key[:]...)
+		start := len(buf)
+		buf = append(buf, payload...)
+		maskBytes(key, buf[start:])
+	}
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+// maskBytes applies the masking key in place; masking twice unmasks.
+func maskBytes(key [4]byte, b []byte) {
+	for i := range b {
+		b[i] ^= key[i%4]
+	}
+}
+
+// SetReadDeadline sets the deadline for ReadMessage.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline for writes.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// RemoteAddr returns the peer's address.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
@@ -0,0 +1,151 @@
+// Package websocket implements the server side of RFC 6455 on top of
+// connections hijacked from the server package.
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/headers"
+	"github.com/DanilShapilov/httpfromtcp/internal/request"
+	"github.com/DanilShapilov/httpfromtcp/internal/response"
+)
+
+// acceptGUID is appended to the client key to prove the server understood
+// the handshake (RFC 6455 section 1.3).
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// DefaultMaxMessageSize bounds messages when Upgrader.MaxMessageSize is 0.
+const DefaultMaxMessageSize = 1 << 20
+
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+// Upgrader turns HTTP requests into WebSocket connections.
+type Upgrader struct {
+	// MaxMessageSize is the largest message, after reassembling fragments,
+	// a peer may send. Bigger ones close the connection with 1009.
+	MaxMessageSize int
+	// Subprotocols the server speaks, in order of preference.
+	Subprotocols []string
+	// CheckOrigin decides whether a browser request from another origin is
+	// allowed. By default the Origin host must match the Host header.
+	CheckOrigin func(req *request.Request) bool
+}
+
+// Upgrade completes the handshake for req and takes the connection over.
+// When the request is not a valid WebSocket handshake it answers with an
+// error status itself and returns an error wrapping ErrBadHandshake.
+func (u *Upgrader) Upgrade(w *response.Writer, req *request.Request) (*Conn, error) {
+	key, status, err := u.checkHandshake(req)
+	if err != nil {
+		h := response.GetDefaultHeaders(len(err.Error()))
+		if status == response.StatusCodeUpgradeRequired {
+			h.Override("Sec-WebSocket-Version", "13")
+			h.Override("Upgrade", "websocket")
+		}
+		w.WriteStatusLine(status)
+		w.WriteHeaders(h)
+		w.WriteBody([]byte(err.Error()))
+		return nil, fmt.Errorf("%w: %v", ErrBadHandshake, err)
+	}
+
+	h := headers.NewHeaders()
+	h.Set("Upgrade", "websocket")
+	h.Set("Connection", "Upgrade")
+	h.Set("Sec-WebSocket-Accept", AcceptKey(key))
+	subprotocol := u.selectSubprotocol(req)
+	if subprotocol != "" {
+		h.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+	if err := w.WriteStatusLine(response.StatusCodeSwitchingProtocols); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return nil, err
+	}
+	netConn, buffered, err := w.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	maxSize := u.MaxMessageSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxMessageSize
+	}
+	c := newConn(netConn, buffered, true, maxSize)
+	c.Subprotocol = subprotocol
+	return c, nil
+}
+
+// checkHandshake validates the opening handshake (RFC 6455 section 4.2.1)
+// and returns the client key, or the status to reject it with.
+func (u *Upgrader) checkHandshake(req *request.Request) (string, response.StatusCode, error) {
+	if req.RequestLine.Method != "GET" {
+		return "", response.StatusCodeBadRequest, errors.New("method must be GET")
+	}
+	if upgrade, _ := req.Headers.Get("upgrade"); !hasToken(upgrade, "websocket") {
+		return "", response.StatusCodeUpgradeRequired, errors.New("missing Upgrade: websocket")
+	}
+	if connection, _ := req.Headers.Get("connection"); !hasToken(connection, "upgrade") {
+		return "", response.StatusCodeBadRequest, errors.New("missing Connection: Upgrade")
+	}
+	if version, _ := req.Headers.Get("sec-websocket-version"); version != "13" {
+		return "", response.StatusCodeUpgradeRequired, errors.New("unsupported Sec-WebSocket-Version")
+	}
+	key, _ := req.Headers.Get("sec-websocket-key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return "", response.StatusCodeBadRequest, errors.New("invalid Sec-WebSocket-Key")
+	}
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(req) {
+		return "", response.StatusCodeForbidden, errors.New("origin not allowed")
+	}
+	return key, 0, nil
+}
+
+// sameOrigin allows requests without an Origin, which don't come from
+// browsers, and requests whose Origin host is the Host header.
+func sameOrigin(req *request.Request) bool {
+	origin, exists := req.Headers.Get("origin")
+	if !exists {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	host, _ := req.Headers.Get("host")
+	return strings.EqualFold(u.Host, host)
+}
+
+func (u *Upgrader) selectSubprotocol(req *request.Request) string {
+	offered, _ := req.Headers.Get("sec-websocket-protocol")
+	for _, p := range u.Subprotocols {
+		if hasToken(offered, p) {
+			return p
+		}
+	}
+	return ""
+}
+
+// AcceptKey returns the Sec-WebSocket-Accept value for a client key.
+func AcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func hasToken(list, token string) bool {
+	for _, t := range strings.Split(list, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
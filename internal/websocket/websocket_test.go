@@ -0,0 +1,175 @@
+package websocket
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/DanilShapilov/httpfromtcp/internal/request"
+	"github.com/DanilShapilov/httpfromtcp/internal/response"
+	"github.com/DanilShapilov/httpfromtcp/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testKey = "dGhlIHNhbXBsZSBub25jZQ=="
+
+// startEcho serves an upgrader that echoes every message and reports how
+// the connection ended on closed.
+func startEcho(t *testing.T, u *Upgrader) (string, <-chan error) {
+	t.Helper()
+	closed := make(chan error, 1)
+	srv, err := server.ServeConfig(server.Config{Addr: "127.0.0.1:0"}, func(w *response.Writer, req *request.Request) {
+		c, err := u.Upgrade(w, req)
+		if err != nil {
+			return
+		}
+		for {
+			typ, msg, err := c.ReadMessage()
+			if err != nil {
+				closed <- err
+				return
+			}
+			c.WriteMessage(typ, msg)
+		}
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { srv.Close() })
+	return srv.Addr().String(), closed
+}
+
+// dial performs the handshake with extra request headers and returns the
+// raw response along with the connection.
+func dial(t *testing.T, addr, extra string) (net.Conn, *response.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	fmt.Fprintf(conn, "GET /ws HTTP/1.1\r\nHost: %s\r\n%s\r\n", addr, extra)
+	res, err := response.NewReader(conn).ReadResponseHeader("GET")
+	require.NoError(t, err)
+	return conn, res
+}
+
+func dialWebSocket(t *testing.T, addr string) *Conn {
+	t.Helper()
+	conn, res := dial(t, addr, "Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: "+testKey+"\r\n")
+	require.Equal(t, response.StatusCodeSwitchingProtocols, res.StatusLine.StatusCode)
+	return newConn(conn, nil, false, DefaultMaxMessageSize)
+}
+
+func TestHandshake(t *testing.T) {
+	addr, _ := startEcho(t, &Upgrader{Subprotocols: []string{"chat"}})
+
+	// Test: Accept key from RFC 6455 section 1.3
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", AcceptKey(testKey))
+
+	// Test: Successful upgrade with a subprotocol
+	_, res := dial(t, addr, "Upgrade: websocket\r\nConnection: keep-alive, Upgrade\r\nSec-WebSocket-Version: 13\r\n"+
+		"Sec-WebSocket-Key: "+testKey+"\r\nSec-WebSocket-Protocol: superchat, chat\r\n")
+	assert.Equal(t, response.StatusCodeSwitchingProtocols, res.StatusLine.StatusCode)
+	accept, _ := res.Headers.Get("sec-websocket-accept")
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", accept)
+	protocol, _ := res.Headers.Get("sec-websocket-protocol")
+	assert.Equal(t, "chat", protocol)
+
+	// Test: Invalid handshakes are rejected
+	cases := []struct {
+		extra  string
+		status response.StatusCode
+	}{
+		{"Connection: Upgrade\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: " + testKey + "\r\n", 426},
+		{"Upgrade: websocket\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: " + testKey + "\r\n", 400},
+		{"Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 8\r\nSec-WebSocket-Key: " + testKey + "\r\n", 426},
+		{"Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: short\r\n", 400},
+		{"Upgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: " + testKey + "\r\nOrigin: https://evil.example\r\n", 403},
+	}
+	for _, tc := range cases {
+		_, res := dial(t, addr, tc.extra)
+		assert.Equal(t, tc.status, res.StatusLine.StatusCode, tc.extra)
+	}
+}
+
+func TestMessages(t *testing.T) {
+	addr, closed := startEcho(t, &Upgrader{})
+	c := dialWebSocket(t, addr)
+
+	// Test: Text and binary messages are echoed
+	require.NoError(t, c.WriteMessage(TextMessage, []byte("hello")))
+	typ, msg, err := c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, TextMessage, typ)
+	assert.Equal(t, "hello", string(msg))
+
+	big := bytes.Repeat([]byte{0xAB}, 70000)
+	require.NoError(t, c.WriteMessage(BinaryMessage, big))
+	typ, msg, err = c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, BinaryMessage, typ)
+	assert.Equal(t, big, msg)
+
+	// Test: Fragments are reassembled, pings in between are answered
+	c.wmu.Lock()
+	require.NoError(t, c.writeFrameLocked(false, opText, []byte("frag")))
+	require.NoError(t, c.writeFrameLocked(true, opPing, []byte("are you there")))
+	require.NoError(t, c.writeFrameLocked(false, opContinuation, []byte("men")))
+	require.NoError(t, c.writeFrameLocked(true, opContinuation, []byte("ted")))
+	c.wmu.Unlock()
+	f, err := c.readFrame(DefaultMaxMessageSize)
+	require.NoError(t, err)
+	assert.Equal(t, byte(opPong), f.opcode)
+	assert.Equal(t, "are you there", string(f.payload))
+	_, msg, err = c.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "fragmented", string(msg))
+
+	// Test: Close handshake
+	require.NoError(t, c.Close(CloseNormal, "bye"))
+	err = <-closed
+	var closeErr *CloseError
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, CloseNormal, closeErr.Code)
+	assert.Equal(t, "bye", closeErr.Reason)
+}
+
+func TestProtocolErrors(t *testing.T) {
+	expectClose := func(t *testing.T, c *Conn, closed <-chan error, code int) {
+		t.Helper()
+		_, _, err := c.ReadMessage()
+		var closeErr *CloseError
+		require.ErrorAs(t, err, &closeErr)
+		assert.Equal(t, code, closeErr.Code)
+		require.ErrorAs(t, <-closed, &closeErr)
+		assert.Equal(t, code, closeErr.Code)
+	}
+
+	// Test: Messages over the limit close with 1009
+	addr, closed := startEcho(t, &Upgrader{MaxMessageSize: 10})
+	c := dialWebSocket(t, addr)
+	c.wmu.Lock()
+	c.writeFrameLocked(false, opBinary, []byte("123456"))
+	c.writeFrameLocked(true, opContinuation, []byte("789012"))
+	c.wmu.Unlock()
+	expectClose(t, c, closed, CloseMessageTooBig)
+
+	// Test: Unmasked client frames close with 1002
+	addr, closed = startEcho(t, &Upgrader{})
+	c = dialWebSocket(t, addr)
+	c.conn.Write([]byte{0x81, 0x02, 'h', 'i'})
+	expectClose(t, c, closed, CloseProtocolError)
+
+	// Test: Invalid UTF-8 text closes with 1007
+	addr, closed = startEcho(t, &Upgrader{})
+	c = dialWebSocket(t, addr)
+	c.WriteMessage(TextMessage, []byte{0xff, 0xfe})
+	expectClose(t, c, closed, CloseInvalidPayload)
+
+	// Test: Continuation without a message closes with 1002
+	addr, closed = startEcho(t, &Upgrader{})
+	c = dialWebSocket(t, addr)
+	c.wmu.Lock()
+	c.writeFrameLocked(true, opContinuation, []byte("x"))
+	c.wmu.Unlock()
+	expectClose(t, c, closed, CloseProtocolError)
+}